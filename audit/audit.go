@@ -0,0 +1,224 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/mlp/api/log"
+)
+
+const (
+	SinkNone   string = "none"
+	SinkStdout string = "stdout"
+	SinkFile   string = "file"
+	SinkHTTP   string = "http"
+
+	DecisionAllowed string = "allowed"
+	DecisionDenied  string = "denied"
+
+	httpSinkTimeoutSeconds = 5
+	httpSinkBufferSize     = 1000
+)
+
+// Record is a single audit entry of secrets being accessed or released for a pod.
+// Secret values must never be set on a record, only the keys that were requested.
+type Record struct {
+	Timestamp      time.Time `json:"timestamp"`
+	AdmissionUID   string    `json:"admissionUID"`
+	Operation      string    `json:"operation"`
+	Username       string    `json:"username"`
+	UserUID        string    `json:"userUID,omitempty"`
+	UserGroups     []string  `json:"userGroups,omitempty"`
+	Namespace      string    `json:"namespace"`
+	Pod            string    `json:"pod"`
+	ServiceAccount string    `json:"serviceAccount,omitempty"`
+	Project        string    `json:"project"`
+	Keys           []string  `json:"keys"`
+	Decision       string    `json:"decision"`
+	Reason         string    `json:"reason,omitempty"`
+}
+
+// Sink receives the audit records emitted by the webhook. The sinks holding a resource, or buffering the records,
+// also implement io.Closer, to be closed once the records are no longer written
+type Sink interface {
+	Write(record Record) error
+}
+
+// NewSink creates the Sink configured by AuditConfig
+func NewSink(cfg config.AuditConfig) (Sink, error) {
+	switch cfg.Sink {
+	case SinkNone:
+		return NoopSink{}, nil
+	case SinkStdout:
+		return NewWriterSink(os.Stdout), nil
+	case SinkFile:
+		if cfg.FilePath == "" {
+			return nil, fmt.Errorf("audit file path is required for sink '%v'", cfg.Sink)
+		}
+		f, err := os.OpenFile(cfg.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit file: %v", err)
+		}
+		return &FileSink{WriterSink: NewWriterSink(f), f: f}, nil
+	case SinkHTTP:
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("audit endpoint is required for sink '%v'", cfg.Sink)
+		}
+		return NewHTTPSink(cfg.Endpoint, &http.Client{Timeout: httpSinkTimeoutSeconds * time.Second}, httpSinkBufferSize), nil
+	default:
+		return nil, fmt.Errorf("unsupported audit sink '%v'", cfg.Sink)
+	}
+}
+
+// NoopSink discards every record
+type NoopSink struct{}
+
+func (NoopSink) Write(_ Record) error {
+	return nil
+}
+
+// WriterSink writes each record as a line of JSON to the underlying writer
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// FileSink writes each record as a line of JSON to a file
+type FileSink struct {
+	*WriterSink
+	f *os.File
+}
+
+// Close closes the file, no record can be written after
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// HTTPSink posts each record as JSON to an endpoint. The records are posted in the background, so that a slow
+// endpoint does not delay the admission. A record is dropped when bufferSize records are already waiting
+type HTTPSink struct {
+	endpoint   string
+	httpClient *http.Client
+	records    chan Record
+	done       chan struct{}
+	// mu guards records from being written once it is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewHTTPSink(endpoint string, httpClient *http.Client, bufferSize int) *HTTPSink {
+	s := &HTTPSink{
+		endpoint:   endpoint,
+		httpClient: httpClient,
+		records:    make(chan Record, bufferSize),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *HTTPSink) Write(record Record) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return fmt.Errorf("audit sink is closed, record dropped")
+	}
+	select {
+	case s.records <- record:
+		return nil
+	default:
+		return fmt.Errorf("audit buffer of %d records is full, record dropped", cap(s.records))
+	}
+}
+
+// Close posts the records already written and stops the sink, the records written after are dropped
+func (s *HTTPSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.records)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *HTTPSink) run() {
+	defer close(s.done)
+	for record := range s.records {
+		if err := s.post(record); err != nil {
+			log.Errorf("failed to post audit record for pod: '%v' in namespace: '%v': %v", record.Pod, record.Namespace, err)
+		}
+	}
+}
+
+func (s *HTTPSink) post(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Post(s.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("audit endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// MemorySink keeps records in memory, it is intended for tests
+type MemorySink struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Write(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+// Records returns a copy of the records written so far
+func (s *MemorySink) Records() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Record(nil), s.records...)
+}
+
+// Reset clears the records written so far
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = nil
+}
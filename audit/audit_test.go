@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+var record = Record{
+	AdmissionUID: "uid",
+	Operation:    "CREATE",
+	Username:     "user",
+	Namespace:    "testgroup",
+	Pod:          "pod-with-secret",
+	Project:      "testgroup",
+	Keys:         []string{"testsecretkey"},
+	Decision:     DecisionAllowed,
+}
+
+func TestWriterSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewWriterSink(buf)
+
+	assert.NoError(t, sink.Write(record))
+	assert.NoError(t, sink.Write(record))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Equal(t, 2, len(lines))
+	for _, line := range lines {
+		got := Record{}
+		assert.NoError(t, json.Unmarshal(line, &got))
+		assert.Equal(t, record, got)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var received [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL, server.Client(), 10)
+	assert.NoError(t, sink.Write(record))
+	assert.NoError(t, sink.Write(record))
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, 2, len(received))
+	// the records written after the sink is closed are dropped
+	assert.Equal(t, fmt.Errorf("audit sink is closed, record dropped"), sink.Write(record))
+	assert.NoError(t, sink.Close())
+	for _, body := range received {
+		got := Record{}
+		assert.NoError(t, json.Unmarshal(body, &got))
+		assert.Equal(t, record, got)
+	}
+
+	// failure of the endpoint is logged, the next records are still posted
+	received = nil
+	sink = NewHTTPSink(server.URL+"/fail", server.Client(), 10)
+	assert.NoError(t, sink.Write(record))
+	assert.NoError(t, sink.Write(record))
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, 2, len(received))
+}
+
+func TestHTTPSinkSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	// the writes return while the endpoint is blocked, until the buffer is full
+	sink := NewHTTPSink(server.URL, server.Client(), 1)
+	start := time.Now()
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = sink.Write(record)
+	}
+	assert.Equal(t, fmt.Errorf("audit buffer of 1 records is full, record dropped"), err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	close(release)
+	assert.NoError(t, sink.Close())
+}
+
+func TestFileSink(t *testing.T) {
+	filePath := t.TempDir() + "/audit.log"
+	sink, err := NewSink(config.AuditConfig{Sink: SinkFile, FilePath: filePath})
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Write(record))
+
+	// the file is closed on shutdown
+	closer, ok := sink.(io.Closer)
+	assert.True(t, ok)
+	assert.NoError(t, closer.Close())
+	assert.Error(t, sink.Write(record))
+
+	data, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	got := Record{}
+	assert.NoError(t, json.Unmarshal(bytes.TrimSpace(data), &got))
+	assert.Equal(t, record, got)
+}
+
+func TestNewSink(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.AuditConfig
+		expectedErr error
+	}{
+		{
+			name: "none",
+			cfg:  config.AuditConfig{Sink: SinkNone},
+		},
+		{
+			name: "stdout",
+			cfg:  config.AuditConfig{Sink: SinkStdout},
+		},
+		{
+			name: "file",
+			cfg:  config.AuditConfig{Sink: SinkFile, FilePath: t.TempDir() + "/audit.log"},
+		},
+		{
+			name:        "file without path",
+			cfg:         config.AuditConfig{Sink: SinkFile},
+			expectedErr: fmt.Errorf("audit file path is required for sink 'file'"),
+		},
+		{
+			name:        "http without endpoint",
+			cfg:         config.AuditConfig{Sink: SinkHTTP},
+			expectedErr: fmt.Errorf("audit endpoint is required for sink 'http'"),
+		},
+		{
+			name:        "unsupported",
+			cfg:         config.AuditConfig{Sink: "kafka"},
+			expectedErr: fmt.Errorf("unsupported audit sink 'kafka'"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, err := NewSink(tt.cfg)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, sink)
+				if closer, ok := sink.(io.Closer); ok {
+					assert.NoError(t, closer.Close())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+const (
+	AuthNone      string = "none"
+	AuthTokenFile string = "token-file"
+	AuthOAuth2    string = "oauth2"
+	AuthGoogle    string = "google"
+	AuthMTLS      string = "mtls"
+
+	// googleServiceAccountKey is the type of the credentials that can mint an ID token for any audience
+	googleServiceAccountKey = "service_account"
+)
+
+// NewHTTPClient creates the http client authenticating to MLP with the mode configured by MLPConfig. The first
+// token is requested up front, so a mode that cannot be initialised fails at startup instead of on admission
+func NewHTTPClient(ctx context.Context, cfg config.MLPConfig) (*http.Client, error) {
+	var source oauth2.TokenSource
+	switch cfg.AuthMode {
+	case AuthNone:
+	case AuthMTLS:
+		if cfg.AuthCertFile == "" || cfg.AuthKeyFile == "" {
+			return nil, fmt.Errorf("mlp auth cert file and key file are required for mode '%v'", cfg.AuthMode)
+		}
+	case AuthTokenFile:
+		if cfg.AuthTokenFile == "" {
+			return nil, fmt.Errorf("mlp auth token file is required for mode '%v'", cfg.AuthMode)
+		}
+		source = newFileTokenSource(cfg.AuthTokenFile)
+	case AuthOAuth2:
+		if cfg.AuthTokenURL == "" || cfg.AuthClientID == "" || cfg.AuthClientSecretFile == "" {
+			return nil, fmt.Errorf("mlp auth token url, client id and client secret file are required for mode '%v'",
+				cfg.AuthMode)
+		}
+		clientSecret, err := os.ReadFile(cfg.AuthClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mlp auth client secret: %v", err)
+		}
+		credentials := &clientcredentials.Config{
+			ClientID:     cfg.AuthClientID,
+			ClientSecret: strings.TrimSpace(string(clientSecret)),
+			TokenURL:     cfg.AuthTokenURL,
+			Scopes:       cfg.AuthScopes,
+		}
+		source = credentials.TokenSource(ctx)
+	case AuthGoogle:
+		var err error
+		source, err = newGoogleTokenSource(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported mlp auth mode '%v'", cfg.AuthMode)
+	}
+
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return &http.Client{Transport: transport}, nil
+	}
+	if _, err := source.Token(); err != nil {
+		return nil, fmt.Errorf("failed to get mlp auth token with mode '%v': %v", cfg.AuthMode, err)
+	}
+	return &http.Client{Transport: &oauth2.Transport{Source: source, Base: transport}}, nil
+}
+
+// newTransport creates the transport to MLP, verifying it with AuthCAFile and presenting the client certificate
+// of the mtls mode when they are configured
+func newTransport(cfg config.MLPConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.AuthCAFile == "" && cfg.AuthMode != AuthMTLS {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.AuthCAFile != "" {
+		caCert, err := os.ReadFile(cfg.AuthCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mlp auth ca file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificate found in mlp auth ca file '%v'", cfg.AuthCAFile)
+		}
+	}
+	if cfg.AuthMode == AuthMTLS {
+		cert, err := tls.LoadX509KeyPair(cfg.AuthCertFile, cfg.AuthKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load mlp auth client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// fileTokenSource reads the bearer token from a file, and reads it again when the file is modified,
+// so that a token rotated by a mounted secret is picked up without a restart
+type fileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   *oauth2.Token
+}
+
+func newFileTokenSource(path string) *fileTokenSource {
+	return &fileTokenSource{path: path}
+}
+
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mlp auth token file: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && info.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mlp auth token file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return nil, fmt.Errorf("mlp auth token file '%v' is empty", s.path)
+	}
+	s.token = &oauth2.Token{AccessToken: token, TokenType: "Bearer"}
+	s.modTime = info.ModTime()
+	return s.token, nil
+}
+
+// newGoogleTokenSource creates the source of Google ID tokens for AuthAudience. Service account keys and the
+// metadata server mint a token for the audience, other credentials such as user credentials carry an ID token
+// of their own
+func newGoogleTokenSource(ctx context.Context, cfg config.MLPConfig) (oauth2.TokenSource, error) {
+	var creds *google.Credentials
+	if cfg.AuthCredentialsFile != "" {
+		data, err := os.ReadFile(cfg.AuthCredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read google credentials file: %v", err)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse google credentials file: %v", err)
+		}
+	} else {
+		var err error
+		creds, err = google.FindDefaultCredentials(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find google default credentials: %v", err)
+		}
+	}
+
+	var credentialsType struct {
+		Type string `json:"type"`
+	}
+	if len(creds.JSON) > 0 {
+		if err := json.Unmarshal(creds.JSON, &credentialsType); err != nil {
+			return nil, fmt.Errorf("failed to parse google credentials: %v", err)
+		}
+	}
+	if len(creds.JSON) == 0 || credentialsType.Type == googleServiceAccountKey {
+		source, err := idtoken.NewTokenSource(ctx, cfg.AuthAudience, option.WithCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create google id token source: %v", err)
+		}
+		return source, nil
+	}
+	return oauth2.ReuseTokenSource(nil, &idTokenSource{source: creds.TokenSource}), nil
+}
+
+// idTokenSource passes on the id_token of the tokens of source as the bearer token
+type idTokenSource struct {
+	source oauth2.TokenSource
+}
+
+func (s *idTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	idToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("google token did not contain an id_token")
+	}
+	return &oauth2.Token{AccessToken: idToken, TokenType: "Bearer", Expiry: token.Expiry}, nil
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	dir := t.TempDir()
+	emptyFile := filepath.Join(dir, "empty")
+	assert.NoError(t, os.WriteFile(emptyFile, nil, 0600))
+
+	tests := []struct {
+		name        string
+		cfg         config.MLPConfig
+		expectedErr error
+	}{
+		{
+			name: "none",
+			cfg:  config.MLPConfig{AuthMode: AuthNone},
+		},
+		{
+			name:        "unsupported",
+			cfg:         config.MLPConfig{AuthMode: "basic"},
+			expectedErr: fmt.Errorf("unsupported mlp auth mode 'basic'"),
+		},
+		{
+			name:        "token file without path",
+			cfg:         config.MLPConfig{AuthMode: AuthTokenFile},
+			expectedErr: fmt.Errorf("mlp auth token file is required for mode 'token-file'"),
+		},
+		{
+			name: "empty token file",
+			cfg:  config.MLPConfig{AuthMode: AuthTokenFile, AuthTokenFile: emptyFile},
+			expectedErr: fmt.Errorf("failed to get mlp auth token with mode 'token-file': "+
+				"mlp auth token file '%v' is empty", emptyFile),
+		},
+		{
+			name: "oauth2 without client id",
+			cfg:  config.MLPConfig{AuthMode: AuthOAuth2, AuthTokenURL: "http://auth", AuthClientSecretFile: emptyFile},
+			expectedErr: fmt.Errorf("mlp auth token url, client id and client secret file are required " +
+				"for mode 'oauth2'"),
+		},
+		{
+			name:        "mtls without key",
+			cfg:         config.MLPConfig{AuthMode: AuthMTLS, AuthCertFile: emptyFile},
+			expectedErr: fmt.Errorf("mlp auth cert file and key file are required for mode 'mtls'"),
+		},
+		{
+			name: "mtls with invalid certificate",
+			cfg:  config.MLPConfig{AuthMode: AuthMTLS, AuthCertFile: emptyFile, AuthKeyFile: emptyFile},
+			expectedErr: fmt.Errorf("failed to load mlp auth client certificate: " +
+				"tls: failed to find any PEM data in certificate input"),
+		},
+		{
+			name:        "invalid ca file",
+			cfg:         config.MLPConfig{AuthMode: AuthNone, AuthCAFile: emptyFile},
+			expectedErr: fmt.Errorf("no certificate found in mlp auth ca file '%v'", emptyFile),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient, err := NewHTTPClient(context.Background(), tt.cfg)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, httpClient)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientTokenFile(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("first\n"), 0600))
+	httpClient, err := NewHTTPClient(context.Background(), config.MLPConfig{
+		AuthMode:      AuthTokenFile,
+		AuthTokenFile: tokenFile,
+	})
+	assert.NoError(t, err)
+
+	_, err = httpClient.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer first", authorization)
+
+	// the rotated token is used once the file is modified
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("second"), 0600))
+	assert.NoError(t, os.Chtimes(tokenFile, time.Now(), time.Now().Add(time.Minute)))
+	_, err = httpClient.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer second", authorization)
+}
+
+func TestNewHTTPClientOAuth2(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+			assert.Equal(t, "mlp.secrets", r.PostForm.Get("scope"))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"client-token","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "client-secret")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("secret"), 0600))
+	cfg := config.MLPConfig{
+		AuthMode:             AuthOAuth2,
+		AuthTokenURL:         server.URL + "/token",
+		AuthClientID:         "dap",
+		AuthClientSecretFile: secretFile,
+		AuthScopes:           []string{"mlp.secrets"},
+	}
+	httpClient, err := NewHTTPClient(context.Background(), cfg)
+	assert.NoError(t, err)
+
+	_, err = httpClient.Get(server.URL + "/v1/projects")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer client-token", authorization)
+
+	// startup fails when no token can be obtained
+	cfg.AuthTokenURL = server.URL + "/not-found"
+	_, err = NewHTTPClient(context.Background(), cfg)
+	assert.ErrorContains(t, err, "failed to get mlp auth token with mode 'oauth2'")
+}
+
+func TestNewHTTPClientCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caFile, caCert, 0600))
+
+	httpClient, err := NewHTTPClient(context.Background(), config.MLPConfig{AuthMode: AuthNone, AuthCAFile: caFile})
+	assert.NoError(t, err)
+	_, err = httpClient.Get(server.URL)
+	assert.NoError(t, err)
+
+	// the server is not trusted by the system roots
+	httpClient, err = NewHTTPClient(context.Background(), config.MLPConfig{AuthMode: AuthNone})
+	assert.NoError(t, err)
+	_, err = httpClient.Get(server.URL)
+	assert.Error(t, err)
+}
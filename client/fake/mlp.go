@@ -0,0 +1,222 @@
+// Package fake provides an in-process MLP API, serving the projects and secrets endpoints called by the webhook.
+// It is intended for tests and local runs without MLP
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	mlp "github.com/caraml-dev/mlp/api/client"
+)
+
+// Projects is the content of the fake MLP, of project name to its secrets of secret name to value
+type Projects map[string]map[string]string
+
+// MLPServer is a MLP API on a httptest.Server. The projects and secrets are numbered in the order of their names.
+// Latency, failures and authentication can be set to exercise the client against an unhealthy MLP
+type MLPServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	projects []mlp.Project
+	secrets  map[int32][]mlp.Secret
+	token    string
+	latency  time.Duration
+	failures []int
+	requests int
+}
+
+// NewMLPServer starts a MLP API with the projects, the server is to be closed by the caller
+func NewMLPServer(projects Projects) *MLPServer {
+	s := &MLPServer{secrets: map[int32][]mlp.Secret{}}
+	names := make([]string, 0, len(projects))
+	for name := range projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.addProject(name)
+		secretNames := make([]string, 0, len(projects[name]))
+		for secretName := range projects[name] {
+			secretNames = append(secretNames, secretName)
+		}
+		sort.Strings(secretNames)
+		for _, secretName := range secretNames {
+			s.SetSecret(name, secretName, projects[name][secretName])
+		}
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewMLPServerFromFile starts a MLP API with the projects read from a YAML or JSON file
+func NewMLPServerFromFile(path string) (*MLPServer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	projects := Projects{}
+	if err := yaml.Unmarshal(data, &projects); err != nil {
+		return nil, fmt.Errorf("failed to parse fake mlp projects file: %v", err)
+	}
+	return NewMLPServer(projects), nil
+}
+
+// SetSecret creates or updates the secret of the project, the project is created when it does not exist
+func (s *MLPServer) SetSecret(project string, name string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	projectID := s.projectID(project)
+	if projectID == 0 {
+		projectID = s.addProjectLocked(project)
+	}
+	secrets := s.secrets[projectID]
+	for i := range secrets {
+		if secrets[i].Name == name {
+			secrets[i].Data = value
+			return
+		}
+	}
+	s.secrets[projectID] = append(secrets, mlp.Secret{
+		ID:   int32(len(secrets) + 1),
+		Name: name,
+		Data: value,
+	})
+}
+
+// RequireToken rejects the requests without the bearer token with 401, an empty token accepts any request
+func (s *MLPServer) RequireToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// SetLatency delays every response, the request is abandoned when the client gives up
+func (s *MLPServer) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// FailNext responds to the next requests with the status codes, one request per code.
+// http.StatusOK serves the request as usual, to fail a later request
+func (s *MLPServer) FailNext(statusCodes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statusCodes...)
+}
+
+// Requests returns the number of requests received, including the failed ones
+func (s *MLPServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *MLPServer) addProject(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addProjectLocked(name)
+}
+
+func (s *MLPServer) addProjectLocked(name string) int32 {
+	projectID := int32(len(s.projects) + 1)
+	s.projects = append(s.projects, mlp.Project{ID: projectID, Name: name})
+	return projectID
+}
+
+// projectID returns the id of the project, or 0 when it does not exist
+func (s *MLPServer) projectID(name string) int32 {
+	for _, project := range s.projects {
+		if project.Name == name {
+			return project.ID
+		}
+	}
+	return 0
+}
+
+func (s *MLPServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	latency, token := s.latency, s.token
+	failure := 0
+	if len(s.failures) > 0 {
+		failure, s.failures = s.failures[0], s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+	if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+		return
+	}
+	if failure != 0 && failure != http.StatusOK {
+		writeError(w, failure, http.StatusText(failure))
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %v is not supported", r.Method))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == "/v1/projects" {
+		projects := []mlp.Project{}
+		name := r.URL.Query().Get("name")
+		for _, project := range s.projects {
+			if name == "" || project.Name == name {
+				projects = append(projects, project)
+			}
+		}
+		writeJSON(w, projects)
+		return
+	}
+
+	projectID, ok := strings.CutPrefix(path, "/v1/projects/")
+	if ok {
+		projectID, ok = strings.CutSuffix(projectID, "/secrets")
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("path %v not found", r.URL.Path))
+		return
+	}
+	id, err := strconv.Atoi(projectID)
+	if err != nil || id < 1 || id > len(s.projects) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("project id %v not found", projectID))
+		return
+	}
+	secrets := s.secrets[int32(id)]
+	if secrets == nil {
+		secrets = []mlp.Secret{}
+	}
+	writeJSON(w, secrets)
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -3,31 +3,59 @@ package client
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/antihax/optional"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
 
+	"github.com/caraml-dev/dap-secret-webhook/config"
 	mlp "github.com/caraml-dev/mlp/api/client"
 	"github.com/caraml-dev/mlp/api/pkg/instrumentation/metrics"
 )
 
-const (
-	mlpQueryTimeoutSeconds = 30
-)
-
 type MLPClient interface {
-	GetMLPSecretValue(project string, name string) (string, error)
+	// GetMLPSecretValue returns the value of the secret name in the project. The call is abandoned when ctx is done
+	GetMLPSecretValue(ctx context.Context, project string, name string) (string, error)
+}
+
+// NotFoundError is returned when the project or secret does not exist in MLP, as opposed to MLP failing to respond
+type NotFoundError struct {
+	msg string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.msg
 }
 
 type APIClient struct {
 	mlp.APIClient
+	retrier *retrier
+	// budget is the total time to get a secret, including retries
+	budget time.Duration
+	// group coalesces the concurrent identical calls to MLP, e.g. from the pods of a map task
+	group   singleflight.Group
+	limiter *projectLimiter
+	// throttled holds the keys of the calls waiting for the limiter of their project
+	throttled sync.Map
+}
+
+func NewAPIClient(apiClient *mlp.APIClient, cfg config.MLPConfig) *APIClient {
+	return &APIClient{
+		APIClient: *apiClient,
+		retrier:   newRetrier(cfg),
+		budget:    cfg.RetryBudget,
+		limiter:   newProjectLimiter(cfg),
+	}
 }
 
 const (
 	MLPSecretsNotFound string = "flyte_dsw_mlp_secrets_not_found"
 	MLPRequestsTotal   string = "flyte_dsw_mlp_requests_total"
+	MLPCoalescedCalls  string = "flyte_dsw_mlp_coalesced_calls_total"
 )
 
 var MLPSecretsNotFoundMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
@@ -44,30 +72,44 @@ var MLPRequestsTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
 	[]string{"project", "status"},
 )
 
-// GetMLPSecretValue takes in project and secret name and return the secret value/data from mlp client
-func (m *APIClient) GetMLPSecretValue(project string, secretName string) (string, error) {
+var MLPCoalescedCallsMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: MLPCoalescedCalls,
+	Help: "Number of call to MLP API served by an identical call in flight, instead of calling MLP",
+},
+	[]string{"project"},
+)
+
+// GetMLPSecretValue takes in project and secret name and return the secret value/data from mlp client.
+// Transient failures of MLP are retried within the retry budget, or until the deadline of ctx when it is earlier
+func (m *APIClient) GetMLPSecretValue(ctx context.Context, project string, secretName string) (value string, err error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), mlpQueryTimeoutSeconds*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.budget)
 	defer cancel()
 
-	var err error
-	defer func(err error) {
+	defer func() {
 		status := metrics.GetStatusString(err == nil)
 		MLPRequestsTotalMetrics.WithLabelValues(project, status).Inc()
-	}(err)
+	}()
 
-	mlpProject, err := m.getMLPProject(project)
+	mlpProject, err := m.getMLPProject(ctx, project)
 	if err != nil {
-		return "", fmt.Errorf("cannot get project from mlp, %v", err.Error())
+		return "", fmt.Errorf("cannot get project from mlp, %w", err)
 	}
 
-	secrets, resp, err := m.SecretApi.V1ProjectsProjectIdSecretsGet(ctx, mlpProject.ID)
+	result, err := m.coalesce(ctx, fmt.Sprintf("secrets/%d", mlpProject.ID), project, func(ctx context.Context) (interface{}, error) {
+		var secrets []mlp.Secret
+		err := m.retrier.do(ctx, project, func(ctx context.Context) (*http.Response, error) {
+			var resp *http.Response
+			var err error
+			secrets, resp, err = m.SecretApi.V1ProjectsProjectIdSecretsGet(ctx, mlpProject.ID)
+			return resp, err
+		})
+		return secrets, err
+	})
 	if err != nil {
 		return "", err
 	}
-	if resp != nil && resp.Body != nil {
-		defer resp.Body.Close()
-	}
+	secrets := result.([]mlp.Secret)
 
 	for _, mlpSecret := range secrets {
 		if mlpSecret.Name == secretName {
@@ -75,10 +117,10 @@ func (m *APIClient) GetMLPSecretValue(project string, secretName string) (string
 		}
 	}
 	MLPSecretsNotFoundMetrics.WithLabelValues(project).Inc()
-	return "", fmt.Errorf("cannot find secret '%v' from mlp project '%v'", secretName, project)
+	return "", &NotFoundError{msg: fmt.Sprintf("cannot find secret '%v' from mlp project '%v'", secretName, project)}
 }
 
-func (m *APIClient) getMLPProject(namespace string) (*mlp.Project, error) {
+func (m *APIClient) getMLPProject(ctx context.Context, namespace string) (*mlp.Project, error) {
 
 	var options *mlp.ProjectApiV1ProjectsGetOpts
 	if len(namespace) > 0 {
@@ -86,18 +128,67 @@ func (m *APIClient) getMLPProject(namespace string) (*mlp.Project, error) {
 			Name: optional.NewString(namespace),
 		}
 	}
-	projects, resp, err := m.ProjectApi.V1ProjectsGet(context.Background(), options)
+	result, err := m.coalesce(ctx, "projects/"+namespace, namespace, func(ctx context.Context) (interface{}, error) {
+		var projects []mlp.Project
+		err := m.retrier.do(ctx, namespace, func(ctx context.Context) (*http.Response, error) {
+			var resp *http.Response
+			var err error
+			projects, resp, err = m.ProjectApi.V1ProjectsGet(ctx, options)
+			return resp, err
+		})
+		return projects, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	if resp != nil && resp.Body != nil {
-		defer resp.Body.Close()
-	}
+	projects := result.([]mlp.Project)
 
 	for _, project := range projects {
 		if project.Name == namespace {
 			return &project, nil
 		}
 	}
-	return nil, fmt.Errorf("cannot find project '%v'from mlp client", namespace)
+	return nil, &NotFoundError{msg: fmt.Sprintf("cannot find project '%v'from mlp client", namespace)}
+}
+
+// coalesce makes a single call of fn for the concurrent calls of the same key, and returns its result to all of them.
+// The call has its own deadline of the retry budget, so a caller giving up does not fail the others. Each caller
+// returns as soon as its ctx is done. The result is shared and must not be modified.
+// The call is made within the rate and concurrency limits of the project, else a *ThrottledError is returned. It is
+// also returned to a caller whose deadline is reached while the call waits for the limiter
+func (m *APIClient) coalesce(
+	ctx context.Context,
+	key string,
+	project string,
+	fn func(ctx context.Context) (interface{}, error),
+) (interface{}, error) {
+	called := false
+	resultCh := m.group.DoChan(key, func() (interface{}, error) {
+		called = true
+		callCtx, cancel := context.WithTimeout(context.Background(), m.budget)
+		defer cancel()
+		m.throttled.Store(key, struct{}{})
+		release, err := m.limiter.acquire(callCtx, project)
+		m.throttled.Delete(key)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return fn(callCtx)
+	})
+	select {
+	case <-ctx.Done():
+		if _, ok := m.throttled.Load(key); ok && ctx.Err() == context.DeadlineExceeded {
+			MLPThrottledTotalMetrics.WithLabelValues(project, throttleReasonDeadline).Inc()
+			return nil, &ThrottledError{msg: fmt.Sprintf(
+				"too many requests to mlp for project '%v', no call to mlp allowed before the deadline, retry later",
+				project)}
+		}
+		return nil, ctx.Err()
+	case result := <-resultCh:
+		if !called {
+			MLPCoalescedCallsMetrics.WithLabelValues(project).Inc()
+		}
+		return result.Val, result.Err
+	}
 }
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caraml-dev/dap-secret-webhook/client/fake"
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	mlp "github.com/caraml-dev/mlp/api/client"
+)
+
+func newFakeAPIClient(server *fake.MLPServer, httpClient *http.Client) *APIClient {
+	cfg := mlp.NewConfiguration()
+	cfg.BasePath = server.URL
+	cfg.HTTPClient = httpClient
+	return NewAPIClient(mlp.NewAPIClient(cfg), config.MLPConfig{
+		RetryMaxAttempts:        3,
+		RetryInitialBackoff:     time.Millisecond,
+		RetryMaxBackoff:         time.Millisecond,
+		RetryBudget:             200 * time.Millisecond,
+		BreakerFailureThreshold: 10,
+		BreakerOpenDuration:     time.Minute,
+	})
+}
+
+func TestGetMLPSecretValue(t *testing.T) {
+	tests := []struct {
+		name             string
+		project          string
+		secretName       string
+		setup            func(server *fake.MLPServer)
+		expected         string
+		expectedErr      string
+		expectedNotFound bool
+		expectedRequests int
+	}{
+		{
+			name:             "ok",
+			project:          "testgroup",
+			secretName:       "testsecretkey",
+			expected:         "testsecretdata",
+			expectedRequests: 2,
+		},
+		{
+			name:             "ok among projects and secrets",
+			project:          "othergroup",
+			secretName:       "othersecretkey",
+			expected:         "othersecretdata",
+			expectedRequests: 2,
+		},
+		{
+			name:             "secret not found",
+			project:          "testgroup",
+			secretName:       "missing",
+			expectedErr:      "cannot find secret 'missing' from mlp project 'testgroup'",
+			expectedNotFound: true,
+			expectedRequests: 2,
+		},
+		{
+			name:             "project not found",
+			project:          "missing",
+			secretName:       "testsecretkey",
+			expectedErr:      "cannot get project from mlp, cannot find project 'missing'from mlp client",
+			expectedNotFound: true,
+			expectedRequests: 1,
+		},
+		{
+			name:       "transient failures are retried",
+			project:    "testgroup",
+			secretName: "testsecretkey",
+			setup: func(server *fake.MLPServer) {
+				server.FailNext(http.StatusServiceUnavailable, http.StatusOK, http.StatusBadGateway)
+			},
+			expected:         "testsecretdata",
+			expectedRequests: 4,
+		},
+		{
+			name:       "retries exhausted",
+			project:    "testgroup",
+			secretName: "testsecretkey",
+			setup: func(server *fake.MLPServer) {
+				server.FailNext(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+			},
+			expectedErr:      "cannot get project from mlp, 500 Internal Server Error",
+			expectedRequests: 3,
+		},
+		{
+			name:       "unauthorized is not retried",
+			project:    "testgroup",
+			secretName: "testsecretkey",
+			setup: func(server *fake.MLPServer) {
+				server.RequireToken("token")
+			},
+			expectedErr:      "cannot get project from mlp, 401 Unauthorized",
+			expectedRequests: 1,
+		},
+		{
+			name:       "mlp slower than the budget",
+			project:    "testgroup",
+			secretName: "testsecretkey",
+			setup: func(server *fake.MLPServer) {
+				server.SetLatency(time.Second)
+			},
+			expectedErr:      "context deadline exceeded",
+			expectedRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewMLPServer(fake.Projects{
+				"othergroup": {"othersecretkey": "othersecretdata"},
+				"testgroup":  {"anothersecretkey": "anothersecretdata", "testsecretkey": "testsecretdata"},
+			})
+			defer server.Close()
+			if tt.setup != nil {
+				tt.setup(server)
+			}
+
+			apiClient := newFakeAPIClient(server, server.Client())
+			value, err := apiClient.GetMLPSecretValue(context.Background(), tt.project, tt.secretName)
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				var notFound *NotFoundError
+				assert.Equal(t, tt.expectedNotFound, errors.As(err, &notFound))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, value)
+			}
+			assert.Equal(t, tt.expectedRequests, server.Requests())
+		})
+	}
+}
+
+func TestGetMLPSecretValueAuthenticated(t *testing.T) {
+	server, err := fake.NewMLPServerFromFile("../test/mlp/projects.yaml")
+	assert.NoError(t, err)
+	defer server.Close()
+	server.RequireToken("token")
+
+	httpClient, err := NewHTTPClient(context.Background(), config.MLPConfig{
+		AuthMode:      AuthTokenFile,
+		AuthTokenFile: "../test/mlp/token",
+	})
+	assert.NoError(t, err)
+	value, err := newFakeAPIClient(server, httpClient).GetMLPSecretValue(context.Background(), "testgroup", "testsecretkey")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret_data", value)
+}
+
+func TestGetMLPProject(t *testing.T) {
+	server := fake.NewMLPServer(fake.Projects{"a": nil, "b": {"key": "value"}})
+	defer server.Close()
+
+	project, err := newFakeAPIClient(server, server.Client()).getMLPProject(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.Equal(t, &mlp.Project{ID: 2, Name: "b"}, project)
+}
+
+func TestGetMLPSecretValueCoalesced(t *testing.T) {
+	server := fake.NewMLPServer(fake.Projects{"testgroup": {"a": "1", "b": "2"}})
+	defer server.Close()
+	server.SetLatency(50 * time.Millisecond)
+	apiClient := newFakeAPIClient(server, server.Client())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		secretName, expected := "a", "1"
+		if i%2 == 1 {
+			secretName, expected = "b", "2"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := apiClient.GetMLPSecretValue(context.Background(), "testgroup", secretName)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}()
+	}
+	// a waiter giving up does not fail the call of the others
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := apiClient.GetMLPSecretValue(ctx, "testgroup", "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	wg.Wait()
+	// one call for the project and one for its secrets, regardless of the secret name
+	assert.Equal(t, 2, server.Requests())
+}
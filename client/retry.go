@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/mlp/api/log"
+)
+
+const (
+	MLPRetriesTotal       string = "flyte_dsw_mlp_retries_total"
+	MLPCircuitBreakerOpen string = "flyte_dsw_mlp_circuit_breaker_open"
+)
+
+var MLPRetriesTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: MLPRetriesTotal,
+	Help: "Number of call to MLP API retried after a transient failure",
+},
+	[]string{"project"},
+)
+
+var MLPCircuitBreakerOpenMetrics = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: MLPCircuitBreakerOpen,
+	Help: "1 when the circuit breaker of MLP API is open and calls fail fast, else 0",
+})
+
+// ErrCircuitOpen is returned without calling MLP while the circuit breaker is open
+var ErrCircuitOpen = errors.New("mlp circuit breaker is open after consecutive failures")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker opens after a number of consecutive failures, and fails the calls fast until the open duration has
+// passed. A single trial call is then allowed, which closes the breaker on success or opens it again on failure
+type circuitBreaker struct {
+	mu               sync.Mutex
+	state            breakerState
+	failures         int
+	openedAt         time.Time
+	failureThreshold int
+	openDuration     time.Duration
+	now              func() time.Time
+}
+
+func newCircuitBreaker(failureThreshold int, openDuration time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		now:              time.Now,
+	}
+}
+
+// allow returns true when the call can be made
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// only the trial call is allowed until it completes
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of an allowed call
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		if b.state != breakerClosed {
+			log.Infof("mlp circuit breaker closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		MLPCircuitBreakerOpenMetrics.Set(0)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.failureThreshold > 0 && b.failures >= b.failureThreshold) {
+		if b.state != breakerOpen {
+			log.Warnf("mlp circuit breaker opened after %d consecutive failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = b.now()
+		MLPCircuitBreakerOpenMetrics.Set(1)
+	}
+}
+
+// retrier calls MLP with bounded retries and jittered exponential backoff, through the circuit breaker.
+// Only the idempotent GET calls to MLP are made through the retrier
+type retrier struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	breaker        *circuitBreaker
+}
+
+func newRetrier(cfg config.MLPConfig) *retrier {
+	return &retrier{
+		maxAttempts:    cfg.RetryMaxAttempts,
+		initialBackoff: cfg.RetryInitialBackoff,
+		maxBackoff:     cfg.RetryMaxBackoff,
+		breaker:        newCircuitBreaker(cfg.BreakerFailureThreshold, cfg.BreakerOpenDuration),
+	}
+}
+
+// do calls fn until it succeeds, fails with a non transient error, the attempts are exhausted, or the next retry
+// would not complete before the deadline of the context
+func (r *retrier) do(ctx context.Context, project string, fn func(ctx context.Context) (*http.Response, error)) error {
+	backoff := r.initialBackoff
+	for attempt := 1; ; attempt++ {
+		if !r.breaker.allow() {
+			return ErrCircuitOpen
+		}
+		resp, err := fn(ctx)
+		// only transient failures count against MLP, e.g. a secret not found is a healthy response. A 429 is retried
+		// but is a healthy response too, as the breaker is shared by all the projects and MLP may limit only one
+		transient := err != nil && isTransient(resp, err)
+		r.breaker.record(!transient || isRateLimited(resp))
+		if !transient || attempt >= r.maxAttempts {
+			return err
+		}
+
+		wait := jitter(backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		log.Warnf("retrying mlp call of project '%v' in %v after attempt %d failed: %v", project, wait, attempt, err)
+		MLPRetriesTotalMetrics.WithLabelValues(project).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// isTransient returns true for failures that may succeed on retry: connection errors, 5xx and 429 responses
+func isTransient(resp *http.Response, err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if resp == nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// isRateLimited returns true when MLP rejected the call with 429, e.g. as the project is over its rate limit
+func isRateLimited(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusTooManyRequests
+}
+
+// jitter returns a random duration between half and the full backoff
+func jitter(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
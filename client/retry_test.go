@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	assert.True(t, breaker.allow())
+	breaker.record(false)
+	assert.True(t, breaker.allow())
+	breaker.record(false)
+	// opened after 2 consecutive failures
+	assert.False(t, breaker.allow())
+
+	// a single trial call is allowed after the open duration
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.allow())
+	assert.False(t, breaker.allow())
+	breaker.record(false)
+	assert.False(t, breaker.allow())
+
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.allow())
+	breaker.record(true)
+	assert.True(t, breaker.allow())
+	assert.True(t, breaker.allow())
+}
+
+func TestRetrier(t *testing.T) {
+	errMLP := errors.New("mlp error")
+	respond := func(codes ...int) func(ctx context.Context) (*http.Response, error) {
+		return func(ctx context.Context) (*http.Response, error) {
+			code := codes[0]
+			if len(codes) > 1 {
+				codes = codes[1:]
+			}
+			switch code {
+			case http.StatusOK:
+				return &http.Response{StatusCode: code}, nil
+			case 0:
+				// connection error
+				return nil, errMLP
+			default:
+				return &http.Response{StatusCode: code}, errMLP
+			}
+		}
+	}
+	cfg := config.MLPConfig{
+		RetryMaxAttempts:        3,
+		RetryInitialBackoff:     time.Millisecond,
+		RetryMaxBackoff:         2 * time.Millisecond,
+		BreakerFailureThreshold: 10,
+		BreakerOpenDuration:     time.Minute,
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.MLPConfig
+		timeout  time.Duration
+		codes    []int
+		attempts int
+		err      error
+	}{
+		{
+			name:     "ok",
+			codes:    []int{http.StatusOK},
+			attempts: 1,
+		},
+		{
+			name:     "ok after transient failures",
+			codes:    []int{0, http.StatusServiceUnavailable, http.StatusOK},
+			attempts: 3,
+		},
+		{
+			name:     "attempts exhausted",
+			codes:    []int{http.StatusTooManyRequests},
+			attempts: 3,
+			err:      errMLP,
+		},
+		{
+			name:     "non transient failure is not retried",
+			codes:    []int{http.StatusNotFound},
+			attempts: 1,
+			err:      errMLP,
+		},
+		{
+			name: "retry not within the deadline",
+			cfg: config.MLPConfig{
+				RetryMaxAttempts:        3,
+				RetryInitialBackoff:     time.Second,
+				RetryMaxBackoff:         time.Second,
+				BreakerFailureThreshold: 10,
+			},
+			timeout:  100 * time.Millisecond,
+			codes:    []int{http.StatusBadGateway},
+			attempts: 1,
+			err:      errMLP,
+		},
+		{
+			name: "circuit breaker open",
+			cfg: config.MLPConfig{
+				RetryMaxAttempts:        3,
+				RetryInitialBackoff:     time.Millisecond,
+				RetryMaxBackoff:         time.Millisecond,
+				BreakerFailureThreshold: 2,
+				BreakerOpenDuration:     time.Minute,
+			},
+			codes:    []int{http.StatusInternalServerError},
+			attempts: 2,
+			err:      ErrCircuitOpen,
+		},
+		{
+			name: "rate limited project does not open the circuit breaker",
+			cfg: config.MLPConfig{
+				RetryMaxAttempts:        3,
+				RetryInitialBackoff:     time.Millisecond,
+				RetryMaxBackoff:         time.Millisecond,
+				BreakerFailureThreshold: 2,
+				BreakerOpenDuration:     time.Minute,
+			},
+			codes:    []int{http.StatusTooManyRequests},
+			attempts: 3,
+			err:      errMLP,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg.RetryMaxAttempts == 0 {
+				tt.cfg = cfg
+			}
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			attempts := 0
+			fn := respond(tt.codes...)
+			err := newRetrier(tt.cfg).do(ctx, "project", func(ctx context.Context) (*http.Response, error) {
+				attempts++
+				return fn(ctx)
+			})
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.attempts, attempts)
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		wait := jitter(100 * time.Millisecond)
+		assert.GreaterOrEqual(t, wait, 50*time.Millisecond)
+		assert.LessOrEqual(t, wait, 100*time.Millisecond)
+	}
+	assert.Equal(t, time.Duration(0), jitter(0))
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/caraml-dev/mlp/api/log"
+)
+
+const MLPStaleServedTotal string = "flyte_dsw_mlp_stale_served_total"
+
+var MLPStaleServedTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: MLPStaleServedTotal,
+	Help: "Number of secret served from the last known good value because the call to MLP API failed",
+},
+	[]string{"project"},
+)
+
+type cachedSecret struct {
+	value     string
+	fetchedAt time.Time
+}
+
+// StaleCacheClient keeps the last known good value of each secret, and serves it when the call to MLP fails and the
+// value is not older than maxStaleness. A secret that MLP reports as not found is never served from the cache,
+// so that a deleted secret is not handed out
+type StaleCacheClient struct {
+	client       MLPClient
+	maxStaleness time.Duration
+	now          func() time.Time
+
+	mu      sync.Mutex
+	secrets map[string]cachedSecret
+}
+
+func NewStaleCacheClient(client MLPClient, maxStaleness time.Duration) *StaleCacheClient {
+	return &StaleCacheClient{
+		client:       client,
+		maxStaleness: maxStaleness,
+		now:          time.Now,
+		secrets:      map[string]cachedSecret{},
+	}
+}
+
+// GetMLPSecretValue returns the value of the secret from MLP, or the last known good value when MLP fails.
+// A value served from the cache is recorded on the StaleTracker of ctx, if any
+func (c *StaleCacheClient) GetMLPSecretValue(ctx context.Context, project string, secretName string) (string, error) {
+	cacheKey := project + "/" + secretName
+	value, err := c.client.GetMLPSecretValue(ctx, project, secretName)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.secrets[cacheKey] = cachedSecret{value: value, fetchedAt: c.now()}
+		return value, nil
+	}
+
+	var notFound *NotFoundError
+	if errors.As(err, &notFound) {
+		delete(c.secrets, cacheKey)
+		return "", err
+	}
+	cached, ok := c.secrets[cacheKey]
+	if !ok {
+		return "", err
+	}
+	age := c.now().Sub(cached.fetchedAt)
+	if age > c.maxStaleness {
+		delete(c.secrets, cacheKey)
+		return "", err
+	}
+
+	log.Warnf("serving secret '%v' of mlp project '%v' last fetched %v ago, mlp call failed: %v",
+		secretName, project, age.Round(time.Second), err)
+	MLPStaleServedTotalMetrics.WithLabelValues(project).Inc()
+	if tracker, ok := ctx.Value(staleTrackerKey{}).(*StaleTracker); ok {
+		tracker.add(secretName)
+	}
+	return cached.value, nil
+}
+
+type staleTrackerKey struct{}
+
+// StaleTracker records the secrets served from the cache of StaleCacheClient, instead of MLP
+type StaleTracker struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// WithStaleTracker returns a context recording the secrets served stale by the calls made with it
+func WithStaleTracker(ctx context.Context) (context.Context, *StaleTracker) {
+	tracker := &StaleTracker{names: map[string]bool{}}
+	return context.WithValue(ctx, staleTrackerKey{}, tracker), tracker
+}
+
+func (t *StaleTracker) add(secretName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.names[secretName] = true
+}
+
+// Names returns the sorted names of the secrets served stale
+func (t *StaleTracker) Names() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.names))
+	for name := range t.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sequenceClient returns the values or errors in order, repeating the last one
+type sequenceClient struct {
+	values []string
+	errs   []error
+}
+
+func (s *sequenceClient) GetMLPSecretValue(_ context.Context, _ string, _ string) (string, error) {
+	value, err := s.values[0], s.errs[0]
+	if len(s.values) > 1 {
+		s.values, s.errs = s.values[1:], s.errs[1:]
+	}
+	return value, err
+}
+
+func TestStaleCacheClient(t *testing.T) {
+	errUnavailable := errors.New("mlp unavailable")
+	errNotFound := &NotFoundError{msg: "cannot find secret 'key' from mlp project 'project'"}
+
+	tests := []struct {
+		name          string
+		values        []string
+		errs          []error
+		age           time.Duration
+		expected      string
+		expectedErr   error
+		expectedStale []string
+	}{
+		{
+			name:     "fresh",
+			values:   []string{"old", "new"},
+			errs:     []error{nil, nil},
+			expected: "new",
+		},
+		{
+			name:          "stale within max staleness",
+			values:        []string{"old", ""},
+			errs:          []error{nil, errUnavailable},
+			age:           time.Minute,
+			expected:      "old",
+			expectedStale: []string{"key"},
+		},
+		{
+			name:        "stale beyond max staleness",
+			values:      []string{"old", ""},
+			errs:        []error{nil, errUnavailable},
+			age:         time.Hour,
+			expectedErr: errUnavailable,
+		},
+		{
+			name:        "never fetched",
+			values:      []string{"", ""},
+			errs:        []error{errUnavailable, errUnavailable},
+			expectedErr: errUnavailable,
+		},
+		{
+			name:        "deleted from mlp",
+			values:      []string{"old", ""},
+			errs:        []error{nil, errNotFound},
+			expectedErr: errNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			staleClient := NewStaleCacheClient(&sequenceClient{values: tt.values, errs: tt.errs}, 10*time.Minute)
+			staleClient.now = func() time.Time { return now }
+			_, _ = staleClient.GetMLPSecretValue(context.Background(), "project", "key")
+
+			now = now.Add(tt.age)
+			ctx, tracker := WithStaleTracker(context.Background())
+			value, err := staleClient.GetMLPSecretValue(ctx, "project", "key")
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expected, value)
+			assert.Equal(t, append([]string{}, tt.expectedStale...), tracker.Names())
+		})
+	}
+}
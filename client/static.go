@@ -0,0 +1,25 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// StaticClient serves secrets from a fixed map of MLP secret name to value, regardless of the project.
+// It is intended for simulation and local runs without MLP
+type StaticClient struct {
+	secrets map[string]string
+}
+
+func NewStaticClient(secrets map[string]string) *StaticClient {
+	return &StaticClient{secrets: secrets}
+}
+
+// GetMLPSecretValue returns the value of the secret name, or an error when it is not in the map
+func (s *StaticClient) GetMLPSecretValue(_ context.Context, project string, secretName string) (string, error) {
+	value, ok := s.secrets[secretName]
+	if !ok {
+		return "", &NotFoundError{msg: fmt.Sprintf("cannot find secret '%v' from mlp project '%v'", secretName, project)}
+	}
+	return value, nil
+}
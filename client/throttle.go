@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+const (
+	MLPThrottledTotal string = "flyte_dsw_mlp_throttled_total"
+
+	throttleReasonRateLimit   = "rate_limit"
+	throttleReasonConcurrency = "concurrency"
+	// throttleReasonDeadline is a caller reaching its deadline while waiting for the limiter
+	throttleReasonDeadline = "deadline"
+)
+
+var MLPThrottledTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: MLPThrottledTotal,
+	Help: "Number of call to MLP API rejected by the rate limit or concurrency limit of the project",
+},
+	[]string{"project", "reason"},
+)
+
+// ThrottledError is returned without calling MLP when the project has exhausted its rate or concurrency limit
+type ThrottledError struct {
+	msg string
+}
+
+func (e *ThrottledError) Error() string {
+	return e.msg
+}
+
+// projectLimiter bounds the rate and the concurrency of the calls to MLP of each project, so that a single project
+// launching many pods does not starve the admission of the others
+type projectLimiter struct {
+	rateLimit            float64
+	rateBurst            int
+	projectRateLimits    map[string]float64
+	maxConcurrent        int
+	projectMaxConcurrent map[string]int
+	// wait for a token or a slot until the deadline of the call, instead of failing fast
+	wait bool
+
+	mu        sync.Mutex
+	limiters  map[string]*rate.Limiter
+	bulkheads map[string]chan struct{}
+}
+
+func newProjectLimiter(cfg config.MLPConfig) *projectLimiter {
+	return &projectLimiter{
+		rateLimit:            cfg.RateLimit,
+		rateBurst:            cfg.RateBurst,
+		projectRateLimits:    cfg.ProjectRateLimits,
+		maxConcurrent:        cfg.MaxConcurrent,
+		projectMaxConcurrent: cfg.ProjectMaxConcurrent,
+		wait:                 cfg.ThrottleWait,
+		limiters:             map[string]*rate.Limiter{},
+		bulkheads:            map[string]chan struct{}{},
+	}
+}
+
+// acquire takes a token and a concurrency slot of the project for a call, or returns a *ThrottledError.
+// release has to be called once the call completes
+func (l *projectLimiter) acquire(ctx context.Context, project string) (release func(), err error) {
+	limiter, bulkhead := l.limits(project)
+	if limiter != nil {
+		if l.wait {
+			// fails without waiting when the token is not available before the deadline
+			err = limiter.Wait(ctx)
+		} else if !limiter.Allow() {
+			err = fmt.Errorf("no token available")
+		}
+		if err != nil {
+			MLPThrottledTotalMetrics.WithLabelValues(project, throttleReasonRateLimit).Inc()
+			return nil, &ThrottledError{msg: fmt.Sprintf(
+				"too many requests to mlp for project '%v', rate limit of %v per second exceeded, retry later",
+				project, limiter.Limit())}
+		}
+	}
+	if bulkhead == nil {
+		return func() {}, nil
+	}
+
+	acquired := false
+	if l.wait {
+		select {
+		case bulkhead <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+	} else {
+		select {
+		case bulkhead <- struct{}{}:
+			acquired = true
+		default:
+		}
+	}
+	if !acquired {
+		MLPThrottledTotalMetrics.WithLabelValues(project, throttleReasonConcurrency).Inc()
+		return nil, &ThrottledError{msg: fmt.Sprintf(
+			"too many concurrent requests to mlp for project '%v', limit of %d reached, retry later",
+			project, cap(bulkhead))}
+	}
+	return func() { <-bulkhead }, nil
+}
+
+// limits returns the rate limiter and the bulkhead of the project, nil when the project is not limited
+func (l *projectLimiter) limits(project string) (*rate.Limiter, chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[project]
+	if !ok {
+		limit := l.rateLimit
+		if projectLimit, ok := l.projectRateLimits[project]; ok {
+			limit = projectLimit
+		}
+		if limit > 0 {
+			burst := l.rateBurst
+			if burst < 1 {
+				burst = 1
+			}
+			limiter = rate.NewLimiter(rate.Limit(limit), burst)
+		}
+		l.limiters[project] = limiter
+	}
+
+	bulkhead, ok := l.bulkheads[project]
+	if !ok {
+		maxConcurrent := l.maxConcurrent
+		if projectMax, ok := l.projectMaxConcurrent[project]; ok {
+			maxConcurrent = projectMax
+		}
+		if maxConcurrent > 0 {
+			bulkhead = make(chan struct{}, maxConcurrent)
+		}
+		l.bulkheads[project] = bulkhead
+	}
+	return limiter, bulkhead
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caraml-dev/dap-secret-webhook/client/fake"
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestProjectLimiterRateLimit(t *testing.T) {
+	limiter := newProjectLimiter(config.MLPConfig{
+		RateLimit:         1,
+		RateBurst:         2,
+		ProjectRateLimits: map[string]float64{"unlimited": 0},
+	})
+
+	for i := 0; i < 2; i++ {
+		release, err := limiter.acquire(context.Background(), "project")
+		assert.NoError(t, err)
+		release()
+	}
+	_, err := limiter.acquire(context.Background(), "project")
+	assert.Equal(t, &ThrottledError{
+		msg: "too many requests to mlp for project 'project', rate limit of 1 per second exceeded, retry later",
+	}, err)
+
+	// the limit is per project, and can be lifted for a project
+	_, err = limiter.acquire(context.Background(), "other")
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = limiter.acquire(context.Background(), "unlimited")
+		assert.NoError(t, err)
+	}
+}
+
+func TestProjectLimiterConcurrency(t *testing.T) {
+	limiter := newProjectLimiter(config.MLPConfig{
+		MaxConcurrent:        1,
+		ProjectMaxConcurrent: map[string]int{"big": 2},
+	})
+
+	release, err := limiter.acquire(context.Background(), "project")
+	assert.NoError(t, err)
+	_, err = limiter.acquire(context.Background(), "project")
+	assert.Equal(t, &ThrottledError{
+		msg: "too many concurrent requests to mlp for project 'project', limit of 1 reached, retry later",
+	}, err)
+	release()
+	release, err = limiter.acquire(context.Background(), "project")
+	assert.NoError(t, err)
+	release()
+
+	for i := 0; i < 2; i++ {
+		_, err = limiter.acquire(context.Background(), "big")
+		assert.NoError(t, err)
+	}
+	_, err = limiter.acquire(context.Background(), "big")
+	assert.Error(t, err)
+}
+
+func TestProjectLimiterWait(t *testing.T) {
+	limiter := newProjectLimiter(config.MLPConfig{
+		RateLimit:     20,
+		RateBurst:     1,
+		MaxConcurrent: 1,
+		ThrottleWait:  true,
+	})
+
+	release, err := limiter.acquire(context.Background(), "project")
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release()
+	}()
+	// waits for the next token and the slot to be released
+	start := time.Now()
+	release, err = limiter.acquire(context.Background(), "project")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// gives up at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(ctx, "project")
+	var throttledErr *ThrottledError
+	assert.True(t, errors.As(err, &throttledErr))
+	release()
+}
+
+func TestGetMLPSecretValueThrottled(t *testing.T) {
+	server := fake.NewMLPServer(fake.Projects{"testgroup": {"testsecretkey": "testsecretdata"}})
+	defer server.Close()
+	apiClient := newFakeAPIClient(server, server.Client())
+	apiClient.limiter = newProjectLimiter(config.MLPConfig{RateLimit: 1, RateBurst: 2})
+
+	value, err := apiClient.GetMLPSecretValue(context.Background(), "testgroup", "testsecretkey")
+	assert.NoError(t, err)
+	assert.Equal(t, "testsecretdata", value)
+
+	// the project and secrets calls took the burst, MLP is not called
+	_, err = apiClient.GetMLPSecretValue(context.Background(), "testgroup", "testsecretkey")
+	var throttledErr *ThrottledError
+	assert.True(t, errors.As(err, &throttledErr))
+	assert.Equal(t, "cannot get project from mlp, too many requests to mlp for project 'testgroup', "+
+		"rate limit of 1 per second exceeded, retry later", err.Error())
+	assert.Equal(t, 2, server.Requests())
+}
+
+func TestGetMLPSecretValueThrottleWaitDeadline(t *testing.T) {
+	server := fake.NewMLPServer(fake.Projects{"testgroup": {"testsecretkey": "testsecretdata"}})
+	defer server.Close()
+	apiClient := newFakeAPIClient(server, server.Client())
+	apiClient.limiter = newProjectLimiter(config.MLPConfig{RateLimit: 1, RateBurst: 2, ThrottleWait: true})
+	apiClient.budget = 5 * time.Second
+
+	_, err := apiClient.GetMLPSecretValue(context.Background(), "testgroup", "testsecretkey")
+	assert.NoError(t, err)
+
+	// the caller reaches its deadline while the call waits for the next token, within the retry budget
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = apiClient.GetMLPSecretValue(ctx, "testgroup", "testsecretkey")
+	var throttledErr *ThrottledError
+	assert.True(t, errors.As(err, &throttledErr), err)
+	assert.Equal(t, "cannot get project from mlp, too many requests to mlp for project 'testgroup', "+
+		"no call to mlp allowed before the deadline, retry later", err.Error())
+}
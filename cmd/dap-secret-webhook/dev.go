@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/dap-secret-webhook/webhook"
+)
+
+const (
+	// devPort is the default port in dev mode, as the service port 443 requires privileges
+	devPort = "8443"
+	// devHost is where the api server of a kind or docker desktop cluster reaches the host running the webhook
+	devHost = "host.docker.internal"
+	// devListenHost keeps the webhook, which serves the secrets of the cluster, from being reachable on the network
+	devListenHost = "127.0.0.1"
+	// devName keeps the webhook configurations of a deployed webhook from being overwritten
+	devName           = "dap-secret-webhook-dev"
+	devCertValidity   = 24 * time.Hour
+	devCACertFile     = "ca.pem"
+	devServerCertFile = "server.pem"
+	devServerKeyFile  = "server-key.pem"
+)
+
+// setupDevEnv configures the webhook to run outside of the cluster: the api server calls it at WEBHOOK_URL, by default
+// https://host.docker.internal:8443, with a CA and server certificate generated in dir. The webhook configurations
+// are named apart from the deployed webhook, and ignore the failures of a webhook that is stopped. The variables that
+// are already set are kept, except for the certificates
+func setupDevEnv(dir string) error {
+	for _, env := range [][2]string{
+		{"WEBHOOK_SERVICE_PORT", devPort},
+		{"WEBHOOK_NAME", devName},
+		{"WEBHOOK_FAILURE_POLICY", string(admissionregistrationv1.Ignore)},
+		{"WEBHOOK_LISTEN_HOST", devListenHost},
+	} {
+		if os.Getenv(env[0]) != "" {
+			continue
+		}
+		if err := os.Setenv(env[0], env[1]); err != nil {
+			return err
+		}
+	}
+	webhookURL := os.Getenv("WEBHOOK_URL")
+	if webhookURL == "" {
+		webhookURL = fmt.Sprintf("https://%v:%v", devHost, os.Getenv("WEBHOOK_SERVICE_PORT"))
+		if err := os.Setenv("WEBHOOK_URL", webhookURL); err != nil {
+			return err
+		}
+	}
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url '%v': %v", webhookURL, err)
+	}
+
+	tlsConfig, err := generateDevCerts(dir, []string{parsed.Hostname(), "localhost", "127.0.0.1"})
+	if err != nil {
+		return err
+	}
+	for name, value := range map[string]string{
+		"TLS_CA_CERT_FILE":     tlsConfig.CaCertFile,
+		"TLS_SERVER_CERT_FILE": tlsConfig.ServerCertFile,
+		"TLS_SERVER_KEY_FILE":  tlsConfig.ServerKeyFile,
+	} {
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupDevEnv deletes the webhook configurations of the dev webhook, so that the api server no longer calls it
+func cleanupDevEnv(ctx context.Context, k8sClient kubernetes.Interface, webhookConfig config.WebhookConfig) error {
+	if err := webhook.DeleteMutatingWebhookConfig(ctx, k8sClient, webhookConfig); err != nil {
+		return err
+	}
+	return webhook.DeleteValidatingWebhookConfig(ctx, k8sClient, webhookConfig)
+}
+
+// generateDevCerts writes a self signed CA, and a server certificate for the hosts signed by it, to dir
+func generateDevCerts(dir string, hosts []string) (*config.TLSConfig, error) {
+	notBefore := time.Now().Add(-time.Minute)
+	notAfter := notBefore.Add(devCertValidity)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dap-secret-webhook-dev-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dev ca certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dev server certificate: %v", err)
+	}
+	serverKeyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &config.TLSConfig{
+		CaCertFile:     filepath.Join(dir, devCACertFile),
+		ServerCertFile: filepath.Join(dir, devServerCertFile),
+		ServerKeyFile:  filepath.Join(dir, devServerKeyFile),
+	}
+	files := map[string]*pem.Block{
+		tlsConfig.CaCertFile:     {Type: "CERTIFICATE", Bytes: caDER},
+		tlsConfig.ServerCertFile: {Type: "CERTIFICATE", Bytes: serverDER},
+		tlsConfig.ServerKeyFile:  {Type: "EC PRIVATE KEY", Bytes: serverKeyDER},
+	}
+	for path, block := range files {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write dev certificate: %v", err)
+		}
+	}
+	return tlsConfig, nil
+}
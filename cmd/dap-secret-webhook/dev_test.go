@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestSetupDevEnv(t *testing.T) {
+	// the variables set by setupDevEnv are restored after the test
+	isolateDevEnv(t)
+	t.Setenv("MLP_API_HOST", "http://localhost:8080")
+	assert.NoError(t, os.Unsetenv("WEBHOOK_SERVICE_PORT"))
+	assert.NoError(t, os.Unsetenv("WEBHOOK_URL"))
+	assert.NoError(t, os.Unsetenv("WEBHOOK_NAME"))
+	assert.NoError(t, os.Unsetenv("WEBHOOK_FAILURE_POLICY"))
+	assert.NoError(t, os.Unsetenv("WEBHOOK_LISTEN_HOST"))
+
+	dir := t.TempDir()
+	assert.NoError(t, setupDevEnv(dir))
+	cfg, err := config.InitConfigEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8443), cfg.WebhookConfig.ServicePort)
+	assert.Equal(t, "https://host.docker.internal:8443", cfg.WebhookConfig.URL)
+	// the configurations of a deployed webhook are kept, and the pods are admitted while the webhook is stopped
+	assert.Equal(t, "dap-secret-webhook-dev", cfg.WebhookConfig.Name)
+	assert.Equal(t, "Ignore", cfg.WebhookConfig.FailurePolicy)
+	assert.Equal(t, "127.0.0.1", cfg.WebhookConfig.ListenHost)
+
+	// the server certificate is trusted by the ca registered with the api server, for the url and localhost
+	caCert, err := os.ReadFile(cfg.TLSConfig.CaCertFile)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(caCert))
+	serverCert, err := tls.LoadX509KeyPair(cfg.TLSConfig.ServerCertFile, cfg.TLSConfig.ServerKeyFile)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	assert.NoError(t, err)
+	for _, host := range []string{"host.docker.internal", "localhost", "127.0.0.1"} {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		assert.NoError(t, err, host)
+	}
+}
+
+func TestSetupDevEnvURL(t *testing.T) {
+	isolateDevEnv(t)
+	t.Setenv("WEBHOOK_SERVICE_PORT", "9443")
+	t.Setenv("WEBHOOK_URL", "https://172.18.0.1:9443")
+	t.Setenv("WEBHOOK_LISTEN_HOST", "172.18.0.1")
+
+	assert.NoError(t, setupDevEnv(t.TempDir()))
+	assert.Equal(t, "9443", os.Getenv("WEBHOOK_SERVICE_PORT"))
+	assert.Equal(t, "https://172.18.0.1:9443", os.Getenv("WEBHOOK_URL"))
+	assert.Equal(t, "172.18.0.1", os.Getenv("WEBHOOK_LISTEN_HOST"))
+
+	serverCert, err := tls.LoadX509KeyPair(os.Getenv("TLS_SERVER_CERT_FILE"), os.Getenv("TLS_SERVER_KEY_FILE"))
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "172.18.0.1", leaf.IPAddresses[0].String())
+}
+
+func TestCleanupDevEnv(t *testing.T) {
+	webhookConfig := config.WebhookConfig{Name: "dap-secret-webhook-dev"}
+	k8sClient := fake.NewSimpleClientset(
+		&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "dap-secret-webhook-dev"}},
+		&admissionregistrationv1.ValidatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "dap-secret-webhook-dev"}},
+		&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "dap-secret-webhook"}},
+	)
+
+	assert.NoError(t, cleanupDevEnv(context.Background(), k8sClient, webhookConfig))
+	mutating, err := k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations().List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mutating.Items))
+	assert.Equal(t, "dap-secret-webhook", mutating.Items[0].Name)
+	validating, err := k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, validating.Items)
+
+	// the configurations may already be deleted
+	assert.NoError(t, cleanupDevEnv(context.Background(), k8sClient, webhookConfig))
+}
+
+func isolateDevEnv(t *testing.T) {
+	for _, name := range []string{
+		"WEBHOOK_SERVICE_PORT", "WEBHOOK_URL", "WEBHOOK_NAME", "WEBHOOK_FAILURE_POLICY", "WEBHOOK_LISTEN_HOST",
+		"TLS_CA_CERT_FILE", "TLS_SERVER_CERT_FILE", "TLS_SERVER_KEY_FILE",
+	} {
+		t.Setenv(name, "")
+	}
+}
@@ -8,25 +8,37 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	goruntime "runtime"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 
+	"github.com/caraml-dev/dap-secret-webhook/audit"
 	"github.com/caraml-dev/dap-secret-webhook/client"
+	"github.com/caraml-dev/dap-secret-webhook/client/fake"
 	"github.com/caraml-dev/dap-secret-webhook/config"
 	"github.com/caraml-dev/dap-secret-webhook/webhook"
 	mlp "github.com/caraml-dev/mlp/api/client"
 	"github.com/caraml-dev/mlp/api/log"
-	"github.com/caraml-dev/mlp/api/pkg/auth"
 	v1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var CmdWebhook = &cobra.Command{
@@ -36,96 +48,166 @@ var CmdWebhook = &cobra.Command{
 	Run:   run,
 }
 
+var (
+	// fakeMLPFile is the projects file of the fake MLP to run against, instead of MLP_API_HOST
+	fakeMLPFile string
+	// kubeconfig and kubeContext select the cluster when running outside of it, else the in-cluster config is used
+	kubeconfig  string
+	kubeContext string
+	// dev runs the webhook from a workstation against a cluster, see setupDevEnv
+	dev bool
+)
+
 var admissionScheme = runtime.NewScheme()
 var codecs = serializer.NewCodecFactory(admissionScheme)
 
 func init() {
+	utilruntime.Must(v1beta1.AddToScheme(admissionScheme))
 	utilruntime.Must(v1.AddToScheme(admissionScheme))
-}
 
-func initK8Client() (*kubernetes.Clientset, error) {
+	CmdWebhook.Flags().StringVar(&fakeMLPFile, "fake-mlp", "",
+		"YAML or JSON file of MLP project name to secret name to value, served by an in-process fake MLP for local runs")
+	CmdWebhook.Flags().StringVar(&kubeconfig, "kubeconfig", "",
+		"Kubeconfig of the cluster when running outside of it, defaults to KUBECONFIG or ~/.kube/config")
+	CmdWebhook.Flags().StringVar(&kubeContext, "context", "", "Kubeconfig context of the cluster")
+	CmdWebhook.Flags().BoolVar(&dev, "dev", false,
+		"Run outside of the cluster, with generated certificates and the api server calling WEBHOOK_URL")
+}
 
-	var config *rest.Config
-	var err error
+// k8sUserAgent identifies the calls of the webhook in the audit logs and metrics of the api server
+var k8sUserAgent = fmt.Sprintf("%v/webhook (%v/%v)", webhook.ManagedByValue, goruntime.GOOS, goruntime.GOARCH)
 
-	config, err = rest.InClusterConfig()
+func initK8Client(kubeconfig string, kubeContext string, k8sConfig config.K8sConfig) (*kubernetes.Clientset, error) {
+	restConfig, err := k8sRestConfig(kubeconfig, kubeContext, k8sConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %v", err)
 	}
 
 	// Create a new Kubernetes clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %v", err)
 	}
 	return clientset, nil
 }
 
-func initMLPClient(mlpApiHost string) client.MLPClient {
-	httpClient := http.DefaultClient
+// k8sRestConfig loads the config of the cluster, with the rate limit and user agent of the webhook
+func k8sRestConfig(kubeconfig string, kubeContext string, k8sConfig config.K8sConfig) (*rest.Config, error) {
+	// the in-cluster config is used when there is no kubeconfig, as in the pod of the webhook
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	restConfig.QPS = k8sConfig.QPS
+	restConfig.Burst = k8sConfig.Burst
+	restConfig.UserAgent = k8sUserAgent
+	return restConfig, nil
+}
 
-	googleClient, err := auth.InitGoogleClient(context.Background())
-	if err == nil {
-		httpClient = googleClient
-	} else {
-		log.Infof("Google default credential not found. Fallback to HTTP default client")
+func initMLPClient(mlpConfig config.MLPConfig) (client.MLPClient, error) {
+	httpClient, err := client.NewHTTPClient(context.Background(), mlpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mlp client: %v", err)
 	}
 	cfg := mlp.NewConfiguration()
-	cfg.BasePath = mlpApiHost
+	cfg.BasePath = mlpConfig.APIHost
 	cfg.HTTPClient = httpClient
 
-	return &client.APIClient{
-		APIClient: *mlp.NewAPIClient(cfg),
+	apiClient := client.NewAPIClient(mlp.NewAPIClient(cfg), mlpConfig)
+	if mlpConfig.StaleMaxAge > 0 {
+		return client.NewStaleCacheClient(apiClient, mlpConfig.StaleMaxAge), nil
 	}
+	return apiClient, nil
 }
 
-// admitV1Func handles a v1 admission
-type admitV1Func func(v1.AdmissionReview) *v1.AdmissionResponse
+const (
+	// maxRequestBodyBytes caps the size of an AdmissionReview, which holds at most the new and old pod
+	maxRequestBodyBytes = 7 * 1024 * 1024
+	// serverReadTimeoutSeconds and serverWriteTimeoutSeconds bound a request, the api server waits at most 30s for a webhook
+	serverReadTimeoutSeconds  = 10
+	serverWriteTimeoutSeconds = 30
+	serverIdleTimeoutSeconds  = 120
+)
+
+// admitV1Func handles a v1 admission, ctx is done when the api server gives up on the request
+type admitV1Func func(context.Context, v1.AdmissionReview) *v1.AdmissionResponse
 
 // serve handles the http portion of a request prior to handing to an admit
 // function
 func serve(w http.ResponseWriter, r *http.Request, admit admitV1Func) {
-	var body []byte
-	if r.Body != nil {
-		if data, err := io.ReadAll(r.Body); err == nil {
-			body = data
-		}
-	}
-
 	// verify the content type is accurate
 	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		log.Errorf("contentType=%s, expect application/json", contentType)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("contentType=%s, expect application/json", contentType))
+		return
+	}
+
+	if r.Body == nil {
+		writeError(w, http.StatusBadRequest, "Request has no body")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Request body could not be read: %v", err))
 		return
 	}
 
 	deserializer := codecs.UniversalDeserializer()
 	obj, gvk, err := deserializer.Decode(body, nil, nil)
 	if err != nil {
-		msg := fmt.Sprintf("Request could not be decoded: %v", err)
-		log.Errorf(msg)
-		http.Error(w, msg, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Request could not be decoded: %v", err))
 		return
 	}
 
 	var responseObj runtime.Object
 	switch *gvk {
+	case v1beta1.SchemeGroupVersion.WithKind("AdmissionReview"):
+		requestedAdmissionReview, ok := obj.(*v1beta1.AdmissionReview)
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Expected v1beta1.AdmissionReview but got: %T", obj))
+			return
+		}
+		if requestedAdmissionReview.Request == nil {
+			writeError(w, http.StatusBadRequest, "AdmissionReview has no request")
+			return
+		}
+		responseAdmissionReview := &v1beta1.AdmissionReview{}
+		responseAdmissionReview.SetGroupVersionKind(*gvk)
+		response := admit(r.Context(), v1.AdmissionReview{
+			Request: convertAdmissionRequestToV1(requestedAdmissionReview.Request),
+		})
+		responseAdmissionReview.Response = convertAdmissionResponseToV1beta1(response)
+		responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
+		responseObj = responseAdmissionReview
+
 	case v1.SchemeGroupVersion.WithKind("AdmissionReview"):
 		requestedAdmissionReview, ok := obj.(*v1.AdmissionReview)
 		if !ok {
-			log.Errorf("Expected v1.AdmissionReview but got: %T", obj)
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Expected v1.AdmissionReview but got: %T", obj))
+			return
+		}
+		if requestedAdmissionReview.Request == nil {
+			writeError(w, http.StatusBadRequest, "AdmissionReview has no request")
 			return
 		}
 		responseAdmissionReview := &v1.AdmissionReview{}
 		responseAdmissionReview.SetGroupVersionKind(*gvk)
-		responseAdmissionReview.Response = admit(*requestedAdmissionReview)
+		responseAdmissionReview.Response = admit(r.Context(), *requestedAdmissionReview)
 		responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
 		responseObj = responseAdmissionReview
 
 	default:
-		msg := fmt.Sprintf("Unsupported group version kind: %v", gvk)
-		log.Errorf(msg)
-		http.Error(w, msg, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported group version kind: %v", gvk))
 		return
 	}
 
@@ -141,16 +223,36 @@ func serve(w http.ResponseWriter, r *http.Request, admit admitV1Func) {
 	}
 }
 
-func serveMutate(k8sClient *kubernetes.Clientset,
-	mlpClient client.MLPClient) func(w http.ResponseWriter, r *http.Request) {
+// writeError logs the error and writes it with the status code to the response
+func writeError(w http.ResponseWriter, code int, msg string) {
+	log.Errorf(msg)
+	http.Error(w, msg, code)
+}
+
+func serveMutate(k8sClient *kubernetes.Clientset, listers *webhook.Listers, mlpClient client.MLPClient, auditSink audit.Sink,
+	secretConfig config.SecretConfig, timeout time.Duration) func(w http.ResponseWriter, r *http.Request) {
 
-	dapWebhook := webhook.NewDAPWebhook(k8sClient, mlpClient, codecs.UniversalDeserializer())
+	dapWebhook := webhook.NewDAPWebhook(k8sClient, listers, mlpClient, codecs.UniversalDeserializer(), auditSink, secretConfig, timeout)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		serve(w, r, dapWebhook.Mutate)
 	}
 }
 
+func serveValidate(k8sClient *kubernetes.Clientset, mlpClient client.MLPClient, auditSink audit.Sink,
+	secretConfig config.SecretConfig, timeout time.Duration) func(w http.ResponseWriter, r *http.Request) {
+
+	// validation makes no external call, hence the context and listers are not used
+	dapWebhook := webhook.NewDAPWebhook(k8sClient, nil, mlpClient, codecs.UniversalDeserializer(), auditSink, secretConfig, timeout)
+
+	validate := func(_ context.Context, ar v1.AdmissionReview) *v1.AdmissionResponse {
+		return dapWebhook.Validate(ar)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, validate)
+	}
+}
+
 func configTLS(certFile string, keyFile string) *tls.Config {
 	sCert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
@@ -163,16 +265,68 @@ func configTLS(certFile string, keyFile string) *tls.Config {
 
 func run(cmd *cobra.Command, args []string) {
 
+	if fakeMLPFile != "" {
+		fakeMLP, err := fake.NewMLPServerFromFile(fakeMLPFile)
+		if err != nil {
+			panic(err)
+		}
+		defer fakeMLP.Close()
+		log.Warnf("using fake mlp at %v with the projects of '%v'", fakeMLP.URL, fakeMLPFile)
+		// the fake does not authenticate, it takes the place of the configured MLP
+		utilruntime.Must(os.Setenv("MLP_API_HOST", fakeMLP.URL))
+		utilruntime.Must(os.Setenv("MLP_AUTH_MODE", client.AuthNone))
+	}
+
+	// the server is shut down on a signal, for the audit records to be flushed and the dev configurations deleted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if dev {
+		certDir, err := os.MkdirTemp("", "dap-secret-webhook-dev")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(certDir)
+		if err := setupDevEnv(certDir); err != nil {
+			panic(err)
+		}
+		log.Warnf("running in dev mode, called by the api server at %v", os.Getenv("WEBHOOK_URL"))
+	}
+
 	cfg, err := config.InitConfigEnv()
 	if err != nil {
 		panic(err)
 	}
 
-	k8sClient, err := initK8Client()
+	k8sClient, err := initK8Client(kubeconfig, kubeContext, cfg.K8sConfig)
+	if err != nil {
+		panic(err)
+	}
+	var listers *webhook.Listers
+	if cfg.K8sConfig.InformerEnabled {
+		// the pods are only read by the rotation
+		listers, err = webhook.NewListers(context.Background(), k8sClient, cfg.K8sConfig.InformerResync,
+			cfg.K8sConfig.InformerSyncTimeout, cfg.RotationConfig.Enabled)
+		if err != nil {
+			panic(err)
+		}
+	}
+	mlpClient, err := initMLPClient(cfg.MLPConfig)
 	if err != nil {
 		panic(err)
 	}
-	mlpClient := initMLPClient(cfg.MLPConfig.APIHost)
+
+	auditSink, err := audit.NewSink(cfg.AuditConfig)
+	if err != nil {
+		panic(err)
+	}
+	if closer, ok := auditSink.(io.Closer); ok {
+		// closed after the server is shut down, once no record is written
+		defer func() {
+			if err := closer.Close(); err != nil {
+				log.Errorf("failed to close the audit sink: %v", err)
+			}
+		}()
+	}
 
 	if cfg.PrometheusConfig.Enabled {
 		go func() {
@@ -185,20 +339,56 @@ func run(cmd *cobra.Command, args []string) {
 		}()
 	}
 
+	if cfg.RotationConfig.Enabled {
+		go webhook.NewSecretRefresher(k8sClient, listers, mlpClient, cfg.RotationConfig, cfg.SecretConfig).Run(context.Background())
+	}
+
+	if dev {
+		// the configurations are deleted once the server is shut down on a signal, or fails
+		defer func() {
+			if err := cleanupDevEnv(context.Background(), k8sClient, cfg.WebhookConfig); err != nil {
+				log.Errorf("failed to delete the dev webhook configurations: %v", err)
+			}
+		}()
+	}
 	err = webhook.CreateOrUpdateMutatingWebhookConfig(k8sClient, cfg.WebhookConfig, cfg.TLSConfig.CaCertFile)
 	if err != nil {
 		panic(err)
 	}
+	err = webhook.CreateOrUpdateValidatingWebhookConfig(k8sClient, cfg.WebhookConfig, cfg.TLSConfig.CaCertFile)
+	if err != nil {
+		panic(err)
+	}
 
-	http.HandleFunc(cfg.WebhookConfig.MutatePath, serveMutate(k8sClient, mlpClient))
+	timeout := time.Duration(cfg.WebhookConfig.TimeoutSeconds) * time.Second
+	http.HandleFunc(cfg.WebhookConfig.MutatePath, serveMutate(k8sClient, listers, mlpClient, auditSink, cfg.SecretConfig, timeout))
+	http.HandleFunc(cfg.WebhookConfig.ValidatePath, serveValidate(k8sClient, mlpClient, auditSink, cfg.SecretConfig, timeout))
 	server := &http.Server{
-		Addr:      fmt.Sprintf(":%d", cfg.WebhookConfig.ServicePort),
-		TLSConfig: configTLS(cfg.TLSConfig.ServerCertFile, cfg.TLSConfig.ServerKeyFile),
+		Addr:              net.JoinHostPort(cfg.WebhookConfig.ListenHost, strconv.Itoa(int(cfg.WebhookConfig.ServicePort))),
+		TLSConfig:         configTLS(cfg.TLSConfig.ServerCertFile, cfg.TLSConfig.ServerKeyFile),
+		ReadHeaderTimeout: serverReadTimeoutSeconds * time.Second,
+		ReadTimeout:       serverReadTimeoutSeconds * time.Second,
+		WriteTimeout:      serverWriteTimeoutSeconds * time.Second,
+		IdleTimeout:       serverIdleTimeoutSeconds * time.Second,
 	}
 
-	log.Infof("listening at port: %v", cfg.WebhookConfig.ServicePort)
+	// the requests in flight are served until the api server gives up on them
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		log.Infof("shutting down the server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverWriteTimeoutSeconds*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("failed to shut down the server: %v", err)
+		}
+	}()
+
+	log.Infof("listening at: %v", server.Addr)
 	err = server.ListenAndServeTLS("", "")
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		panic(err)
 	}
+	<-shutdown
 }
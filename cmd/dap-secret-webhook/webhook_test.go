@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	v1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestServe(t *testing.T) {
+	jsonPatchType := v1.PatchTypeJSONPatch
+	jsonPatchTypeV1beta1 := v1beta1.PatchTypeJSONPatch
+	patch := []byte(`[{"op":"add","path":"/metadata/labels","value":{}}]`)
+
+	admit := func(_ context.Context, ar v1.AdmissionReview) *v1.AdmissionResponse {
+		if ar.Request.Operation != v1.Create {
+			return &v1.AdmissionResponse{Allowed: false}
+		}
+		return &v1.AdmissionResponse{
+			Allowed:   true,
+			Patch:     patch,
+			PatchType: &jsonPatchType,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+		response    string
+	}{
+		{
+			name:        "v1",
+			contentType: "application/json",
+			body: `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview",` +
+				`"request":{"uid":"uid-v1","operation":"CREATE"}}`,
+			code: http.StatusOK,
+			response: mustMarshal(t, &v1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Response: &v1.AdmissionResponse{
+					UID:       "uid-v1",
+					Allowed:   true,
+					Patch:     patch,
+					PatchType: &jsonPatchType,
+				},
+			}),
+		},
+		{
+			name:        "v1beta1",
+			contentType: "application/json; charset=utf-8",
+			body: `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview",` +
+				`"request":{"uid":"uid-v1beta1","operation":"CREATE"}}`,
+			code: http.StatusOK,
+			response: mustMarshal(t, &v1beta1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+				Response: &v1beta1.AdmissionResponse{
+					UID:       "uid-v1beta1",
+					Allowed:   true,
+					Patch:     patch,
+					PatchType: &jsonPatchTypeV1beta1,
+				},
+			}),
+		},
+		{
+			name:        "unsupported kind",
+			contentType: "application/json",
+			body:        `{"apiVersion":"v1","kind":"Pod"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "text/plain",
+			body:        `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid"}}`,
+			code:        http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "missing content type",
+			body: `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid"}}`,
+			code: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "malformed body",
+			contentType: "application/json",
+			body:        `{"apiVersion":`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "body too large",
+			contentType: "application/json",
+			body:        `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","padding":"` + strings.Repeat("a", maxRequestBodyBytes) + `"}`,
+			code:        http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "v1 without request",
+			contentType: "application/json",
+			body:        `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			name:        "v1beta1 without request",
+			contentType: "application/json",
+			body:        `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview"}`,
+			code:        http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewBufferString(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+
+			serve(w, r, admit)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.response != "" {
+				assert.JSONEq(t, tt.response, w.Body.String())
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, obj interface{}) string {
+	data, err := json.Marshal(obj)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestK8sRestConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	assert.NoError(t, os.WriteFile(kubeconfig, []byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+- name: prod
+  context:
+    cluster: prod
+current-context: dev
+`), 0600))
+
+	restConfig, err := k8sRestConfig(kubeconfig, "prod", config.K8sConfig{QPS: 20, Burst: 40})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com", restConfig.Host)
+	assert.Equal(t, float32(20), restConfig.QPS)
+	assert.Equal(t, 40, restConfig.Burst)
+	assert.True(t, strings.HasPrefix(restConfig.UserAgent, "dap-secret-webhook/webhook ("), restConfig.UserAgent)
+
+	restConfig, err = k8sRestConfig(kubeconfig, "", config.K8sConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dev.example.com", restConfig.Host)
+
+	_, err = k8sRestConfig(kubeconfig, "missing", config.K8sConfig{})
+	assert.Error(t, err)
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	webhook "github.com/caraml-dev/dap-secret-webhook/cmd/dap-secret-webhook"
+	"github.com/caraml-dev/dap-secret-webhook/cmd/secrets"
+	"github.com/caraml-dev/dap-secret-webhook/cmd/simulate"
 	"github.com/spf13/cobra"
 )
 
 func main() {
 	rootCmd := &cobra.Command{}
 	rootCmd.AddCommand(webhook.CmdWebhook)
+	rootCmd.AddCommand(simulate.CmdSimulate)
+	rootCmd.AddCommand(secrets.CmdSecrets)
 	if err := rootCmd.Execute(); err != nil {
 		panic(err)
 	}
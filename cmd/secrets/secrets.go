@@ -0,0 +1,210 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	secretUtils "github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/spf13/cobra"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// annotationPrefix is the prefix of the annotations Flyte Propeller stores the secrets in
+const annotationPrefix = "flyte.secrets/s"
+
+var CmdSecrets = &cobra.Command{
+	Use:   "secrets",
+	Short: "Decode and encode Flyte Secret annotations",
+}
+
+var cmdDecode = &cobra.Command{
+	Use:   "decode",
+	Short: "Prints the Flyte Secrets in the annotations of a pod as a table",
+	Long: `Prints the Flyte Secrets in the annotations of a pod as a table. The pod is read from a
+YAML or JSON file, or from the cluster of the current kubeconfig context.`,
+	Args: cobra.NoArgs,
+	RunE: runDecode,
+}
+
+var cmdEncode = &cobra.Command{
+	Use:   "encode GROUP:KEY[:MOUNT_REQUIREMENT]...",
+	Short: "Prints the Flyte Secret annotations of the given secrets",
+	Long: `Prints the Flyte Secret annotations of the given secrets. MOUNT_REQUIREMENT is one of ANY, ENV_VAR
+or FILE and defaults to ANY. When a pod file is given, the pod is printed with its Flyte Secret
+annotations replaced.`,
+	Example: "  secrets encode testgroup:testsecretkey:ENV_VAR",
+	Args:    cobra.MinimumNArgs(1),
+	RunE:    runEncode,
+}
+
+var (
+	podFile     string
+	podName     string
+	namespace   string
+	kubeContext string
+)
+
+func init() {
+	cmdDecode.Flags().StringVarP(&podFile, "file", "f", "", "Pod in YAML or JSON")
+	cmdDecode.Flags().StringVar(&podName, "pod", "", "Name of a live pod to read, instead of a file")
+	cmdDecode.Flags().StringVarP(&namespace, "namespace", "n", "default", "Namespace of the live pod")
+	cmdDecode.Flags().StringVar(&kubeContext, "context", "", "Kubeconfig context of the live pod")
+
+	cmdEncode.Flags().StringVarP(&podFile, "file", "f", "", "Pod in YAML or JSON to set the annotations on")
+
+	CmdSecrets.AddCommand(cmdDecode)
+	CmdSecrets.AddCommand(cmdEncode)
+}
+
+func runDecode(cmd *cobra.Command, _ []string) error {
+	var pod *corev1.Pod
+	var err error
+	switch {
+	case podFile != "" && podName != "":
+		return fmt.Errorf("only one of --file or --pod can be set")
+	case podFile != "":
+		pod, err = readPodFile(podFile)
+	case podName != "":
+		pod, err = getLivePod(podName, namespace, kubeContext)
+	default:
+		return fmt.Errorf("one of --file or --pod is required")
+	}
+	if err != nil {
+		return err
+	}
+	return decode(cmd.OutOrStdout(), pod.GetAnnotations())
+}
+
+func runEncode(cmd *cobra.Command, args []string) error {
+	secrets := make([]*core.Secret, 0, len(args))
+	for _, arg := range args {
+		secret, err := parseSecret(arg)
+		if err != nil {
+			return err
+		}
+		secrets = append(secrets, secret)
+	}
+	annotations, err := secretUtils.MarshalSecretsToMapStrings(secrets)
+	if err != nil {
+		return err
+	}
+
+	var out interface{} = annotations
+	if podFile != "" {
+		pod, err := readPodFile(podFile)
+		if err != nil {
+			return err
+		}
+		setSecretAnnotations(pod, annotations)
+		out = pod
+	}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = cmd.OutOrStdout().Write(data)
+	return err
+}
+
+// decode writes a table of the Flyte Secrets in the annotations, ordered by annotation name
+func decode(out io.Writer, annotations map[string]string) error {
+	names := make([]string, 0, len(annotations))
+	for name := range annotations {
+		if strings.HasPrefix(name, annotationPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ANNOTATION\tGROUP\tKEY\tGROUP VERSION\tMOUNT REQUIREMENT")
+	for _, name := range names {
+		secrets, err := secretUtils.UnmarshalStringMapToSecrets(map[string]string{name: annotations[name]})
+		if err != nil {
+			return err
+		}
+		for _, secret := range secrets {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", name, secret.Group, secret.Key, secret.GroupVersion, secret.MountRequirement.String())
+		}
+	}
+	return w.Flush()
+}
+
+// parseSecret parses a secret in the format of GROUP:KEY[:MOUNT_REQUIREMENT]
+func parseSecret(arg string) (*core.Secret, error) {
+	parts := strings.Split(arg, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid secret '%v', expect GROUP:KEY[:MOUNT_REQUIREMENT]", arg)
+	}
+	secret := &core.Secret{
+		Group: parts[0],
+		Key:   parts[1],
+	}
+	if len(parts) == 3 {
+		mountRequirement, ok := core.Secret_MountType_value[strings.ToUpper(parts[2])]
+		if !ok {
+			return nil, fmt.Errorf("invalid mount requirement '%v' of secret '%v'", parts[2], arg)
+		}
+		secret.MountRequirement = core.Secret_MountType(mountRequirement)
+	}
+	return secret, nil
+}
+
+// setSecretAnnotations replaces the Flyte Secret annotations of the pod
+func setSecretAnnotations(pod *corev1.Pod, annotations map[string]string) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	for name := range pod.Annotations {
+		if strings.HasPrefix(name, annotationPrefix) {
+			delete(pod.Annotations, name)
+		}
+	}
+	for name, value := range annotations {
+		pod.Annotations[name] = value
+	}
+}
+
+func readPodFile(path string) (*corev1.Pod, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(data, pod); err != nil {
+		return nil, fmt.Errorf("failed to read pod: %v", err)
+	}
+	return pod, nil
+}
+
+// getLivePod gets the pod from the cluster of the kubeconfig context, the current context is used when it is empty
+func getLivePod(name string, namespace string, kubeContext string) (*corev1.Pod, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+	return clientset.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
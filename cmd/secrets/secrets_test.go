@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	secretUtils "github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	pod, err := readPodFile("../../test/mutate/pod_with_secret.yaml")
+	assert.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, decode(out, pod.GetAnnotations()))
+	assert.Equal(t,
+		"ANNOTATION         GROUP       KEY             GROUP VERSION   MOUNT REQUIREMENT\n"+
+			"flyte.secrets/s0   testgroup   testsecretkey                   ENV_VAR\n",
+		out.String())
+
+	err = decode(out, map[string]string{"flyte.secrets/s0": "not-base32"})
+	assert.Error(t, err)
+}
+
+func TestParseSecret(t *testing.T) {
+	tests := []struct {
+		arg         string
+		want        *core.Secret
+		expectedErr error
+	}{
+		{
+			arg:  "testgroup:testsecretkey",
+			want: &core.Secret{Group: "testgroup", Key: "testsecretkey", MountRequirement: core.Secret_ANY},
+		},
+		{
+			arg:  "testgroup:testsecretkey:env_var",
+			want: &core.Secret{Group: "testgroup", Key: "testsecretkey", MountRequirement: core.Secret_ENV_VAR},
+		},
+		{
+			arg:  ":testsecretkey:FILE",
+			want: &core.Secret{Key: "testsecretkey", MountRequirement: core.Secret_FILE},
+		},
+		{
+			arg:         "testgroup",
+			expectedErr: fmt.Errorf("invalid secret 'testgroup', expect GROUP:KEY[:MOUNT_REQUIREMENT]"),
+		},
+		{
+			arg:         "testgroup:testsecretkey:VOLUME",
+			expectedErr: fmt.Errorf("invalid mount requirement 'VOLUME' of secret 'testgroup:testsecretkey:VOLUME'"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			got, err := parseSecret(tt.arg)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	pod, err := readPodFile("../../test/mutate/pod_with_secret.yaml")
+	assert.NoError(t, err)
+
+	secret, err := parseSecret("othergroup:otherkey:ENV_VAR")
+	assert.NoError(t, err)
+	annotations, err := secretUtils.MarshalSecretsToMapStrings([]*core.Secret{secret})
+	assert.NoError(t, err)
+	setSecretAnnotations(pod, annotations)
+
+	got, err := secretUtils.UnmarshalStringMapToSecrets(pod.GetAnnotations())
+	assert.NoError(t, err)
+	assert.Equal(t, []*core.Secret{secret}, got)
+}
@@ -0,0 +1,218 @@
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/caraml-dev/dap-secret-webhook/audit"
+	"github.com/caraml-dev/dap-secret-webhook/client"
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/dap-secret-webhook/webhook"
+)
+
+var CmdSimulate = &cobra.Command{
+	Use:   "simulate",
+	Short: "Runs DAP Secret Webhook mutation on a pod offline",
+	Long: `Runs DAP Secret Webhook mutation on a Pod or AdmissionReview file against a fake cluster,
+with secret values read from a local file instead of MLP. Prints the JSON patch, the mutated pod
+and the secret that would be created, with the secret values masked.`,
+	RunE: run,
+}
+
+var (
+	inputFile   string
+	secretsFile string
+)
+
+var admissionScheme = runtime.NewScheme()
+var codecs = serializer.NewCodecFactory(admissionScheme)
+
+func init() {
+	utilruntime.Must(v1.AddToScheme(admissionScheme))
+
+	CmdSimulate.Flags().StringVarP(&inputFile, "file", "f", "", "Pod or AdmissionReview in YAML or JSON")
+	CmdSimulate.Flags().StringVarP(&secretsFile, "secrets", "s", "", "YAML or JSON map of MLP secret name to value")
+	utilruntime.Must(CmdSimulate.MarkFlagRequired("file"))
+}
+
+func run(cmd *cobra.Command, _ []string) error {
+	input, err := os.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	secrets := map[string]string{}
+	if secretsFile != "" {
+		data, err := os.ReadFile(secretsFile)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &secrets); err != nil {
+			return fmt.Errorf("failed to parse secrets file: %v", err)
+		}
+	}
+	// the env var and secret names follow the SECRET_ config of the deployed webhook
+	secretConfig, err := config.InitSecretConfigEnv()
+	if err != nil {
+		return err
+	}
+	return simulate(cmd.OutOrStdout(), input, secrets, *secretConfig)
+}
+
+// simulate mutates the pod or admission review in input with a fake clientset and the given secrets,
+// and writes the outcome to out
+func simulate(out io.Writer, input []byte, secrets map[string]string, secretConfig config.SecretConfig) error {
+	ar, err := toAdmissionReview(input)
+	if err != nil {
+		return err
+	}
+
+	k8sClientSet := fake.NewSimpleClientset()
+	dapWebhook := webhook.NewDAPWebhook(
+		k8sClientSet,
+		nil,
+		client.NewStaticClient(secrets),
+		codecs.UniversalDeserializer(),
+		audit.NoopSink{},
+		secretConfig,
+		0,
+	)
+	resp := dapWebhook.Mutate(context.Background(), *ar)
+	if !resp.Allowed {
+		msg := ""
+		if resp.Result != nil {
+			msg = resp.Result.Message
+		}
+		return fmt.Errorf("admission denied: %v", msg)
+	}
+	if ar.Request.Operation == v1.Delete {
+		_, err = fmt.Fprintf(out, "# %v allowed, no mutation\n", ar.Request.Operation)
+		return err
+	}
+
+	pod, err := applyPatch(ar.Request.Object.Raw, resp.Patch)
+	if err != nil {
+		return err
+	}
+	// the fake cluster only holds the secret created by the webhook
+	createdSecrets, err := k8sClientSet.CoreV1().Secrets(pod.Namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	// update of the ephemeral containers reads from the secret created with the pod
+	var secret *corev1.Secret
+	if len(createdSecrets.Items) > 0 {
+		secret = maskSecret(&createdSecrets.Items[0])
+	} else if ar.Request.Operation == v1.Create {
+		return fmt.Errorf("secret was not created")
+	}
+
+	var patch interface{}
+	if len(resp.Patch) > 0 {
+		if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+			return err
+		}
+	}
+	type section struct {
+		title string
+		obj   interface{}
+	}
+	sections := []section{
+		{title: "JSON Patch", obj: patch},
+		{title: "Pod", obj: pod},
+	}
+	if secret != nil {
+		sections = append(sections, section{title: "Secret", obj: secret})
+	}
+	for _, section := range sections {
+		data, err := yaml.Marshal(section.obj)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "---\n# %v\n%v", section.title, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toAdmissionReview reads input as an AdmissionReview, or wraps it in a CREATE AdmissionReview when it is a pod
+func toAdmissionReview(input []byte) (*v1.AdmissionReview, error) {
+	data, err := yaml.YAMLToJSON(input)
+	if err != nil {
+		return nil, err
+	}
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return nil, err
+	}
+
+	switch typeMeta.Kind {
+	case "AdmissionReview":
+		// v1beta1 AdmissionReview shares the same json fields with v1
+		ar := &v1.AdmissionReview{}
+		if err := json.Unmarshal(data, ar); err != nil {
+			return nil, err
+		}
+		if ar.Request == nil {
+			return nil, fmt.Errorf("AdmissionReview has no request")
+		}
+		return ar, nil
+	case "Pod", "":
+		return &v1.AdmissionReview{
+			Request: &v1.AdmissionRequest{
+				UID:       "simulate",
+				Operation: v1.Create,
+				Object:    runtime.RawExtension{Raw: data},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported kind '%v', expect Pod or AdmissionReview", typeMeta.Kind)
+	}
+}
+
+// applyPatch applies the json patch returned by the webhook on the raw pod
+func applyPatch(raw []byte, patch []byte) (*corev1.Pod, error) {
+	patched := raw
+	if len(patch) > 0 {
+		decoded, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, err
+		}
+		patched, err = decoded.Apply(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(patched, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// maskSecret returns a copy of the secret with the values replaced, so that it can be shared safely
+func maskSecret(secret *corev1.Secret) *corev1.Secret {
+	masked := secret.DeepCopy()
+	masked.StringData = map[string]string{}
+	for key, value := range secret.Data {
+		masked.StringData[key] = strings.Repeat("*", 8) + fmt.Sprintf(" (%d bytes)", len(value))
+	}
+	masked.Data = nil
+	return masked
+}
@@ -0,0 +1,99 @@
+package simulate
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestSimulate(t *testing.T) {
+	podWithSecret, err := os.ReadFile("../../test/mutate/pod_with_secret.yaml")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		input       []byte
+		secrets     map[string]string
+		config      config.SecretConfig
+		contains    []string
+		notContains []string
+		expectedErr error
+	}{
+		{
+			name:    "pod",
+			input:   podWithSecret,
+			secrets: map[string]string{"testsecretkey": "secret_data"},
+			contains: []string{
+				"# JSON Patch\n",
+				"- op: add\n  path: /metadata/annotations/dap-secret-webhook~1primary-containers\n",
+				"- op: add\n  path: /spec/containers/0/env\n",
+				"# Pod\n",
+				"name: _FSEC_TESTGROUP_TESTSECRETKEY",
+				"# Secret\n",
+				"testsecretkey: '******** (11 bytes)'",
+			},
+		},
+		{
+			name:    "pod with secret config of the webhook",
+			input:   podWithSecret,
+			secrets: map[string]string{"testsecretkey": "secret_data"},
+			config:  config.SecretConfig{EnvVarPrefix: "_SECRET_", EnvVarExcludeGroup: true},
+			contains: []string{
+				"name: _SECRET_TESTSECRETKEY",
+			},
+			notContains: []string{"_FSEC_TESTGROUP_TESTSECRETKEY"},
+		},
+		{
+			name: "admission review delete",
+			input: []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview",` +
+				`"request":{"uid":"uid","operation":"DELETE","oldObject":{"metadata":{"name":"pod","namespace":"ns"}}}}`),
+			contains: []string{"# DELETE allowed, no mutation\n"},
+		},
+		{
+			name: "admission review ephemeral containers update",
+			input: []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid",` +
+				`"operation":"UPDATE","subResource":"ephemeralcontainers","object":{"metadata":{"name":"pod","namespace":"testgroup",` +
+				`"annotations":{"flyte.secrets/s0":"m4zg54lqhiqce4dfon1go3tpovycectlmv3tuibcorsxg4dtmvrxezlunnsxsiqknvxxk2tul4zgk3lvnfzgk2lfnz1duicfjzlf5vsbkifa"}},` +
+				`"spec":{"containers":[],"ephemeralContainers":[{"name":"debugger","image":"busybox"}]}}}}`),
+			contains: []string{
+				"- op: add\n  path: /spec/ephemeralContainers/0/env\n",
+				"name: _FSEC_TESTGROUP_TESTSECRETKEY",
+			},
+			notContains: []string{"# Secret\n"},
+		},
+		{
+			name:        "secret not found",
+			input:       podWithSecret,
+			secrets:     map[string]string{},
+			expectedErr: fmt.Errorf("admission denied: cannot find secret 'testsecretkey' from mlp project 'testgroup'"),
+		},
+		{
+			name:        "unsupported kind",
+			input:       []byte("kind: Deployment"),
+			expectedErr: fmt.Errorf("unsupported kind 'Deployment', expect Pod or AdmissionReview"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := simulate(out, tt.input, tt.secrets, tt.config)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotContains(t, out.String(), "secret_data")
+			for _, s := range tt.contains {
+				assert.Contains(t, out.String(), s)
+			}
+			for _, s := range tt.notContains {
+				assert.NotContains(t, out.String(), s)
+			}
+		})
+	}
+}
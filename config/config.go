@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -9,6 +11,10 @@ type Config struct {
 	MLPConfig        MLPConfig        `envconfig:"MLP"`
 	WebhookConfig    WebhookConfig    `envconfig:"WEBHOOK"`
 	PrometheusConfig PrometheusConfig `envconfig:"PROMETHEUS"`
+	AuditConfig      AuditConfig      `envconfig:"AUDIT"`
+	RotationConfig   RotationConfig   `envconfig:"ROTATION"`
+	SecretConfig     SecretConfig     `envconfig:"SECRET"`
+	K8sConfig        K8sConfig        `envconfig:"K8S"`
 }
 
 // TLSConfig holds the file path of the required certs to create the Webhook Config and Server
@@ -23,10 +29,10 @@ type PrometheusConfig struct {
 	Port    int32 `split_words:"true" default:"10254"`
 }
 
-// WebhookConfig holds the config for the MutatingWebhookConfiguration to be created
+// WebhookConfig holds the config for the MutatingWebhookConfiguration and ValidatingWebhookConfiguration to be created
 // The default assume dap-secret-webhook name and flyte namespace for the service, webhook server and config
 type WebhookConfig struct {
-	// Name of the MutatingWebhookConfiguration resource
+	// Name of the MutatingWebhookConfiguration and ValidatingWebhookConfiguration resource
 	Name string `split_words:"true" default:"dap-secret-webhook"`
 	// Namespace to be deployed, only one config is required per cluster
 	Namespace string `split_words:"true" default:"flyte"`
@@ -40,10 +46,117 @@ type WebhookConfig struct {
 	ServicePort int32 `split_words:"true" default:"443"`
 	// MutatePath is the endpoint of the service to call for mutate function
 	MutatePath string `split_words:"true" default:"/mutate"`
+	// ValidatePath is the endpoint of the service to call for validate function
+	ValidatePath string `split_words:"true" default:"/validate"`
+	// TimeoutSeconds is how long the api server waits for the webhook, between 1 and 30 seconds
+	TimeoutSeconds int32 `split_words:"true" default:"10"`
+	// FailurePolicy is what the api server does when the webhook cannot be called, Fail or Ignore
+	FailurePolicy string `split_words:"true" default:"Fail"`
+	// URL is where the api server calls the webhook instead of the service, e.g. for a webhook running outside of
+	// the cluster. MutatePath and ValidatePath are appended to it
+	URL string `split_words:"true"`
+	// ListenHost is the address the server listens at, all interfaces when it is not set
+	ListenHost string `split_words:"true"`
+}
+
+// AuditConfig holds the config of where the audit records of secret access are written to
+type AuditConfig struct {
+	// Sink is one of none, stdout, file or http
+	Sink string `split_words:"true" default:"stdout"`
+	// FilePath is the file the records are appended to when Sink is file
+	FilePath string `split_words:"true"`
+	// Endpoint is the url the records are posted to when Sink is http
+	Endpoint string `split_words:"true"`
+}
+
+// SecretConfig holds the config of the k8 secret created for the pods, and the env var the secret is injected as
+type SecretConfig struct {
+	// SharedPerExecution creates one secret for all the pods of a Flyte execution node, instead of one per pod.
+	// The secret is owned by the FlyteWorkflow, not by the pods, hence the secret values are kept in the cluster
+	// after the last pod of the node is deleted, until the FlyteWorkflow is garbage collected
+	SharedPerExecution bool `split_words:"true" default:"false"`
+	// EnvVarPrefix is the prefix of the env var name, the Flyte default is used when empty
+	EnvVarPrefix string `split_words:"true" default:"_FSEC_"`
+	// EnvVarExcludeGroup leaves the secret group out of the env var name, {prefix}{key} instead of {prefix}{group}_{key}
+	EnvVarExcludeGroup bool `split_words:"true" default:"false"`
+	// EnvVarRawNameAlias injects the secret a second time as an env var named after the MLP secret, for containers
+	// that do not use Flyte Secret Manager
+	EnvVarRawNameAlias bool `split_words:"true" default:"false"`
+	// FetchConcurrency is the maximum number of secrets of a pod fetched from MLP at a time
+	FetchConcurrency int `split_words:"true" default:"4"`
+}
+
+// K8sConfig holds the config of the clientset and the informers of the k8 api server
+type K8sConfig struct {
+	// QPS and Burst are the client side rate limit of the calls to the api server
+	QPS   float32 `split_words:"true" default:"50"`
+	Burst int     `split_words:"true" default:"100"`
+	// InformerEnabled caches the secrets managed by the webhook, and the pods with Flyte secrets when rotation is
+	// enabled, instead of reading them from the api server. It requires the list and watch permissions on them
+	InformerEnabled bool `split_words:"true" default:"true"`
+	// InformerResync is the interval the informer caches are resynced at
+	InformerResync time.Duration `split_words:"true" default:"10m"`
+	// InformerSyncTimeout is how long the webhook waits for the informer caches on startup before it fails
+	InformerSyncTimeout time.Duration `split_words:"true" default:"1m"`
+}
+
+// RotationConfig holds the config of refreshing the secret of live pods with the latest value from MLP
+type RotationConfig struct {
+	Enabled bool `split_words:"true" default:"false"`
+	// Interval between each refresh of the secrets
+	Interval time.Duration `split_words:"true" default:"5m"`
+	// Annotation on the pod, with value "true", to opt in for rotation
+	Annotation string `split_words:"true" default:"dap-secret-webhook/rotate"`
 }
 
 type MLPConfig struct {
 	APIHost string `split_words:"true" required:"true"`
+	// RetryMaxAttempts is the maximum number of attempts of a call to MLP, including the first one
+	RetryMaxAttempts int `split_words:"true" default:"3"`
+	// RetryInitialBackoff is the wait before the first retry, doubled on each retry up to RetryMaxBackoff, with jitter
+	RetryInitialBackoff time.Duration `split_words:"true" default:"100ms"`
+	RetryMaxBackoff     time.Duration `split_words:"true" default:"1s"`
+	// RetryBudget is the total time to get a secret from MLP including retries. It has to be within the timeout
+	// of the api server calling the webhook, which is 10s by default
+	RetryBudget time.Duration `split_words:"true" default:"8s"`
+	// BreakerFailureThreshold is the number of consecutive failed calls for the circuit breaker to open,
+	// failing the calls to MLP fast for BreakerOpenDuration before a trial call is allowed
+	BreakerFailureThreshold int           `split_words:"true" default:"5"`
+	BreakerOpenDuration     time.Duration `split_words:"true" default:"30s"`
+	// StaleMaxAge opts in to serve the last known good value of a secret, up to this age, when the calls to MLP fail.
+	// The pods are annotated that the value may be stale. 0 disables it, failing the admission instead
+	StaleMaxAge time.Duration `split_words:"true" default:"0s"`
+	// RateLimit and RateBurst bound the calls to MLP per second of each project, with the limit of a project
+	// overridden by ProjectRateLimits, e.g. 'project-a:5,project-b:20'. 0 is unlimited
+	RateLimit         float64            `split_words:"true" default:"0"`
+	RateBurst         int                `split_words:"true" default:"10"`
+	ProjectRateLimits map[string]float64 `split_words:"true"`
+	// MaxConcurrent bounds the calls to MLP in flight of each project, overridden by ProjectMaxConcurrent. 0 is unlimited
+	MaxConcurrent        int            `split_words:"true" default:"0"`
+	ProjectMaxConcurrent map[string]int `split_words:"true"`
+	// ThrottleWait waits for the rate or concurrency limit within the retry budget, instead of denying the pod
+	// with 429 right away
+	ThrottleWait bool `split_words:"true" default:"false"`
+	// AuthMode is how the webhook authenticates to MLP, one of none, token-file, oauth2, google or mtls.
+	// The webhook fails to start when the configured mode cannot be initialised
+	AuthMode string `split_words:"true" default:"google"`
+	// AuthTokenFile holds the bearer token of the token-file mode, the file is read again when it changes
+	AuthTokenFile string `split_words:"true"`
+	// AuthTokenURL, AuthClientID, AuthClientSecretFile and AuthScopes configure the client credentials flow
+	// of the oauth2 mode
+	AuthTokenURL         string   `split_words:"true"`
+	AuthClientID         string   `split_words:"true"`
+	AuthClientSecretFile string   `split_words:"true"`
+	AuthScopes           []string `split_words:"true"`
+	// AuthAudience is the audience of the Google ID token of the google mode. The application default credentials
+	// are used unless AuthCredentialsFile is set
+	AuthAudience        string `split_words:"true" default:"api.caraml"`
+	AuthCredentialsFile string `split_words:"true"`
+	// AuthCertFile and AuthKeyFile are the client certificate of the mtls mode. AuthCAFile optionally replaces
+	// the system roots to verify MLP, in any mode
+	AuthCertFile string `split_words:"true"`
+	AuthKeyFile  string `split_words:"true"`
+	AuthCAFile   string `split_words:"true"`
 }
 
 func InitConfigEnv() (*Config, error) {
@@ -53,3 +166,13 @@ func InitConfigEnv() (*Config, error) {
 	}
 	return &cfg, nil
 }
+
+// InitSecretConfigEnv reads only the SECRET_ variables, for the commands that render the secrets of a pod the same
+// way as the webhook without its other required config
+func InitSecretConfigEnv() (*SecretConfig, error) {
+	var cfg SecretConfig
+	if err := envconfig.Process("SECRET", &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
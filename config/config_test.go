@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestInitConfigEnv(t *testing.T) {
@@ -44,7 +45,17 @@ func TestInitConfigEnv(t *testing.T) {
 					Port:    10254,
 				},
 				TLSConfig: TLSConfig{},
-				MLPConfig: MLPConfig{},
+				MLPConfig: MLPConfig{
+					RetryMaxAttempts:        3,
+					RetryInitialBackoff:     100 * time.Millisecond,
+					RetryMaxBackoff:         time.Second,
+					RetryBudget:             8 * time.Second,
+					BreakerFailureThreshold: 5,
+					BreakerOpenDuration:     30 * time.Second,
+					RateBurst:               10,
+					AuthMode:                "google",
+					AuthAudience:            "api.caraml",
+				},
 				WebhookConfig: WebhookConfig{
 					Name:             "dap-secret-webhook",
 					Namespace:        "flyte",
@@ -53,6 +64,31 @@ func TestInitConfigEnv(t *testing.T) {
 					ServiceNamespace: "flyte",
 					ServicePort:      443,
 					MutatePath:       "/mutate",
+					ValidatePath:     "/validate",
+					TimeoutSeconds:   10,
+					FailurePolicy:    "Fail",
+				},
+				AuditConfig: AuditConfig{
+					Sink: "stdout",
+				},
+				RotationConfig: RotationConfig{
+					Enabled:    false,
+					Interval:   5 * time.Minute,
+					Annotation: "dap-secret-webhook/rotate",
+				},
+				SecretConfig: SecretConfig{
+					SharedPerExecution: false,
+					EnvVarPrefix:       "_FSEC_",
+					EnvVarExcludeGroup: false,
+					EnvVarRawNameAlias: false,
+					FetchConcurrency:   4,
+				},
+				K8sConfig: K8sConfig{
+					QPS:                 50,
+					Burst:               100,
+					InformerEnabled:     true,
+					InformerResync:      10 * time.Minute,
+					InformerSyncTimeout: time.Minute,
 				},
 			},
 			expectedErr: nil,
@@ -60,19 +96,59 @@ func TestInitConfigEnv(t *testing.T) {
 		{
 			name: "ok with override",
 			envVars: map[string]string{
-				"PROMETHEUS_ENABLED":        "false",
-				"PROMETHEUS_PORT":           "11111",
-				"TLS_SERVER_CERT_FILE":      "/etc/server-cert.pem",
-				"TLS_SERVER_KEY_FILE":       "/etc/server-key.pem",
-				"TLS_CA_CERT_FILE":          "/etc/ca-cert.pem",
-				"MLP_API_HOST":              "mlp:8080",
-				"WEBHOOK_NAME":              "dap",
-				"WEBHOOK_NAMESPACE":         "default",
-				"WEBHOOK_WEBHOOK_NAME":      "dap.default.svc.cluster.local",
-				"WEBHOOK_SERVICE_NAME":      "dap",
-				"WEBHOOK_SERVICE_NAMESPACE": "default",
-				"WEBHOOK_SERVICE_PORT":      "8080",
-				"WEBHOOK_MUTATE_PATH":       "/m",
+				"PROMETHEUS_ENABLED":            "false",
+				"PROMETHEUS_PORT":               "11111",
+				"TLS_SERVER_CERT_FILE":          "/etc/server-cert.pem",
+				"TLS_SERVER_KEY_FILE":           "/etc/server-key.pem",
+				"TLS_CA_CERT_FILE":              "/etc/ca-cert.pem",
+				"MLP_API_HOST":                  "mlp:8080",
+				"MLP_RETRY_MAX_ATTEMPTS":        "5",
+				"MLP_RETRY_INITIAL_BACKOFF":     "50ms",
+				"MLP_RETRY_MAX_BACKOFF":         "2s",
+				"MLP_RETRY_BUDGET":              "5s",
+				"MLP_BREAKER_FAILURE_THRESHOLD": "10",
+				"MLP_BREAKER_OPEN_DURATION":     "1m",
+				"MLP_STALE_MAX_AGE":             "10m",
+				"MLP_RATE_LIMIT":                "2.5",
+				"MLP_RATE_BURST":                "5",
+				"MLP_PROJECT_RATE_LIMITS":       "big:10,small:0.5",
+				"MLP_MAX_CONCURRENT":            "4",
+				"MLP_PROJECT_MAX_CONCURRENT":    "big:8",
+				"MLP_THROTTLE_WAIT":             "true",
+				"MLP_AUTH_MODE":                 "oauth2",
+				"MLP_AUTH_TOKEN_URL":            "https://auth/token",
+				"MLP_AUTH_CLIENT_ID":            "dap",
+				"MLP_AUTH_CLIENT_SECRET_FILE":   "/etc/mlp/client-secret",
+				"MLP_AUTH_SCOPES":               "mlp.read,mlp.secrets",
+				"MLP_AUTH_AUDIENCE":             "mlp",
+				"MLP_AUTH_CA_FILE":              "/etc/mlp/ca.pem",
+				"WEBHOOK_NAME":                  "dap",
+				"WEBHOOK_NAMESPACE":             "default",
+				"WEBHOOK_WEBHOOK_NAME":          "dap.default.svc.cluster.local",
+				"WEBHOOK_SERVICE_NAME":          "dap",
+				"WEBHOOK_SERVICE_NAMESPACE":     "default",
+				"WEBHOOK_SERVICE_PORT":          "8080",
+				"WEBHOOK_MUTATE_PATH":           "/m",
+				"WEBHOOK_VALIDATE_PATH":         "/v",
+				"WEBHOOK_TIMEOUT_SECONDS":       "20",
+				"WEBHOOK_FAILURE_POLICY":        "Ignore",
+				"WEBHOOK_URL":                   "https://host.docker.internal:8443",
+				"WEBHOOK_LISTEN_HOST":           "127.0.0.1",
+				"AUDIT_SINK":                    "http",
+				"AUDIT_ENDPOINT":                "http://audit:8080",
+				"ROTATION_ENABLED":              "true",
+				"ROTATION_INTERVAL":             "30s",
+				"ROTATION_ANNOTATION":           "rotate",
+				"SECRET_SHARED_PER_EXECUTION":   "true",
+				"SECRET_ENV_VAR_PREFIX":         "_SECRET_",
+				"SECRET_ENV_VAR_EXCLUDE_GROUP":  "true",
+				"SECRET_ENV_VAR_RAW_NAME_ALIAS": "true",
+				"SECRET_FETCH_CONCURRENCY":      "8",
+				"K8S_QPS":                       "20.5",
+				"K8S_BURST":                     "40",
+				"K8S_INFORMER_ENABLED":          "false",
+				"K8S_INFORMER_RESYNC":           "1h",
+				"K8S_INFORMER_SYNC_TIMEOUT":     "30s",
 			},
 			want: &Config{
 				PrometheusConfig: PrometheusConfig{
@@ -85,7 +161,27 @@ func TestInitConfigEnv(t *testing.T) {
 					CaCertFile:     "/etc/ca-cert.pem",
 				},
 				MLPConfig: MLPConfig{
-					APIHost: "mlp:8080",
+					APIHost:                 "mlp:8080",
+					RetryMaxAttempts:        5,
+					RetryInitialBackoff:     50 * time.Millisecond,
+					RetryMaxBackoff:         2 * time.Second,
+					RetryBudget:             5 * time.Second,
+					BreakerFailureThreshold: 10,
+					BreakerOpenDuration:     time.Minute,
+					StaleMaxAge:             10 * time.Minute,
+					RateLimit:               2.5,
+					RateBurst:               5,
+					ProjectRateLimits:       map[string]float64{"big": 10, "small": 0.5},
+					MaxConcurrent:           4,
+					ProjectMaxConcurrent:    map[string]int{"big": 8},
+					ThrottleWait:            true,
+					AuthMode:                "oauth2",
+					AuthTokenURL:            "https://auth/token",
+					AuthClientID:            "dap",
+					AuthClientSecretFile:    "/etc/mlp/client-secret",
+					AuthScopes:              []string{"mlp.read", "mlp.secrets"},
+					AuthAudience:            "mlp",
+					AuthCAFile:              "/etc/mlp/ca.pem",
 				},
 				WebhookConfig: WebhookConfig{
 					Name:             "dap",
@@ -95,6 +191,34 @@ func TestInitConfigEnv(t *testing.T) {
 					ServiceNamespace: "default",
 					ServicePort:      8080,
 					MutatePath:       "/m",
+					ValidatePath:     "/v",
+					TimeoutSeconds:   20,
+					FailurePolicy:    "Ignore",
+					URL:              "https://host.docker.internal:8443",
+					ListenHost:       "127.0.0.1",
+				},
+				AuditConfig: AuditConfig{
+					Sink:     "http",
+					Endpoint: "http://audit:8080",
+				},
+				RotationConfig: RotationConfig{
+					Enabled:    true,
+					Interval:   30 * time.Second,
+					Annotation: "rotate",
+				},
+				SecretConfig: SecretConfig{
+					SharedPerExecution: true,
+					EnvVarPrefix:       "_SECRET_",
+					EnvVarExcludeGroup: true,
+					EnvVarRawNameAlias: true,
+					FetchConcurrency:   8,
+				},
+				K8sConfig: K8sConfig{
+					QPS:                 20.5,
+					Burst:               40,
+					InformerEnabled:     false,
+					InformerResync:      time.Hour,
+					InformerSyncTimeout: 30 * time.Second,
 				},
 			},
 			expectedErr: nil,
@@ -123,3 +247,19 @@ func setupNewEnv(envMaps ...map[string]string) {
 		}
 	}
 }
+
+func TestInitSecretConfigEnv(t *testing.T) {
+	t.Setenv("SECRET_ENV_VAR_PREFIX", "_SECRET_")
+	t.Setenv("SECRET_SHARED_PER_EXECUTION", "true")
+	t.Setenv("SECRET_ENV_VAR_EXCLUDE_GROUP", "false")
+	t.Setenv("SECRET_ENV_VAR_RAW_NAME_ALIAS", "false")
+	t.Setenv("SECRET_FETCH_CONCURRENCY", "4")
+
+	cfg, err := InitSecretConfigEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &SecretConfig{
+		SharedPerExecution: true,
+		EnvVarPrefix:       "_SECRET_",
+		FetchConcurrency:   4,
+	}, cfg)
+}
@@ -2,30 +2,34 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // MLPClient is an autogenerated mock type for the MLPClient type
 type MLPClient struct {
 	mock.Mock
 }
 
-// GetMLPSecretValue provides a mock function with given fields: project, name
-func (_m *MLPClient) GetMLPSecretValue(project string, name string) (string, error) {
-	ret := _m.Called(project, name)
+// GetMLPSecretValue provides a mock function with given fields: ctx, project, name
+func (_m *MLPClient) GetMLPSecretValue(ctx context.Context, project string, name string) (string, error) {
+	ret := _m.Called(ctx, project, name)
 
 	var r0 string
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string, string) (string, error)); ok {
-		return rf(project, name)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (string, error)); ok {
+		return rf(ctx, project, name)
 	}
-	if rf, ok := ret.Get(0).(func(string, string) string); ok {
-		r0 = rf(project, name)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(ctx, project, name)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(project, name)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, project, name)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"strings"
+
+	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/flytek8s"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ContainersAnnotation is a comma separated list of the containers to inject the secrets to.
+	// The primary containers of the pod are injected when it is not set
+	ContainersAnnotation = "dap-secret-webhook/containers"
+	// ExcludeContainersAnnotation is a comma separated list of the containers to never inject the secrets to,
+	// e.g. sidecars added by a service mesh
+	ExcludeContainersAnnotation = "dap-secret-webhook/exclude-containers"
+	// PrimaryContainersAnnotation records the containers of the pod when the webhook was first called, the sidecars
+	// added by the webhooks called after it are not primary containers, and are not injected on reinvocation
+	PrimaryContainersAnnotation = "dap-secret-webhook/primary-containers"
+	// FlytePrimaryContainerAnnotation is set by Flyte Propeller on the pods of pod tasks, with the name of the
+	// container running the task
+	FlytePrimaryContainerAnnotation = flytek8s.PrimaryContainerKey
+)
+
+// containerSelector selects the containers of a pod the secrets are injected to, by the container name
+type containerSelector struct {
+	include map[string]bool
+	exclude map[string]bool
+	// primary containers are selected when no container is included explicitly, all are when it is empty
+	primary map[string]bool
+}
+
+// newContainerSelector selects the primary containers of the pod by default. Flyte only names the primary container
+// among the regular containers, hence the init containers of the pod stay selected along with it
+func newContainerSelector(pod *corev1.Pod) containerSelector {
+	primary := parseContainerNames(pod.Annotations[PrimaryContainersAnnotation])
+	if flytePrimary := parseContainerNames(pod.Annotations[FlytePrimaryContainerAnnotation]); len(flytePrimary) > 0 {
+		for _, c := range pod.Spec.InitContainers {
+			if len(primary) == 0 || primary[c.Name] {
+				flytePrimary[c.Name] = true
+			}
+		}
+		primary = flytePrimary
+	}
+	return containerSelector{
+		include: parseContainerNames(pod.Annotations[ContainersAnnotation]),
+		exclude: parseContainerNames(pod.Annotations[ExcludeContainersAnnotation]),
+		primary: primary,
+	}
+}
+
+// selects returns true when the secrets are injected to the container. Exclusion takes precedence over inclusion
+func (s containerSelector) selects(name string) bool {
+	if s.exclude[name] {
+		return false
+	}
+	if len(s.include) > 0 {
+		return s.include[name]
+	}
+	return len(s.primary) == 0 || s.primary[name]
+}
+
+// selectsEphemeral returns true when the secrets are injected to the ephemeral container. Debug containers are
+// attached after the pod is created, hence they are never primary containers and are only selected by name
+func (s containerSelector) selectsEphemeral(name string) bool {
+	if s.exclude[name] {
+		return false
+	}
+	return len(s.include) == 0 || s.include[name]
+}
+
+// recordPrimaryContainers annotates the pod with its containers on the first call of the webhook, unless the containers
+// are selected by name, so that the containers added after are not injected on reinvocation
+func recordPrimaryContainers(pod *corev1.Pod) {
+	if pod.Annotations[ContainersAnnotation] != "" || pod.Annotations[PrimaryContainersAnnotation] != "" {
+		return
+	}
+	names := make([]string, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, c := range pod.Spec.InitContainers {
+		names = append(names, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[PrimaryContainersAnnotation] = strings.Join(names, ",")
+}
+
+func parseContainerNames(value string) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// appendEnvVars appends the env var to the selected containers, the same way as flytewebhook.AppendEnvVars.
+// Env vars that already exist in a container are skipped, so that repeated mutation does not change the container
+func appendEnvVars(containers []corev1.Container, selector containerSelector, envVar corev1.EnvVar) []corev1.Container {
+	for i := range containers {
+		if selector.selects(containers[i].Name) && !hasEnvVar(containers[i].Env, envVar.Name) {
+			containers[i].Env = append(containers[i].Env, envVar)
+		}
+	}
+	return containers
+}
+
+// appendEphemeralEnvVars appends the env var to the selected ephemeral containers, the same way as appendEnvVars
+func appendEphemeralEnvVars(containers []corev1.EphemeralContainer, selector containerSelector, envVar corev1.EnvVar) []corev1.EphemeralContainer {
+	for i := range containers {
+		if selector.selectsEphemeral(containers[i].Name) && !hasEnvVar(containers[i].Env, envVar.Name) {
+			containers[i].Env = append(containers[i].Env, envVar)
+		}
+	}
+	return containers
+}
+
+func hasEnvVar(envVars []corev1.EnvVar, name string) bool {
+	for _, env := range envVars {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
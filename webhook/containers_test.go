@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestInjectSelectedContainers(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		injected    []string
+	}{
+		{
+			name:     "all containers when the primary containers are not known",
+			injected: []string{"init", "app", "istio-proxy", "debugger"},
+		},
+		{
+			name:        "flyte primary container and init containers",
+			annotations: map[string]string{FlytePrimaryContainerAnnotation: "app"},
+			injected:    []string{"init", "app", "debugger"},
+		},
+		{
+			name: "init containers added after the first call of the webhook are not injected",
+			annotations: map[string]string{
+				FlytePrimaryContainerAnnotation: "app",
+				PrimaryContainersAnnotation:     "app,istio-proxy",
+			},
+			injected: []string{"app", "debugger"},
+		},
+		{
+			name:        "containers of the pod on the first call of the webhook",
+			annotations: map[string]string{PrimaryContainersAnnotation: "init,app"},
+			injected:    []string{"init", "app", "debugger"},
+		},
+		{
+			name: "included containers take precedence over primary containers",
+			annotations: map[string]string{
+				FlytePrimaryContainerAnnotation: "app",
+				ContainersAnnotation:            "istio-proxy",
+			},
+			injected: []string{"istio-proxy"},
+		},
+		{
+			name:        "included containers",
+			annotations: map[string]string{ContainersAnnotation: "app, debugger"},
+			injected:    []string{"app", "debugger"},
+		},
+		{
+			name:        "excluded containers",
+			annotations: map[string]string{ExcludeContainersAnnotation: "istio-proxy"},
+			injected:    []string{"init", "app", "debugger"},
+		},
+		{
+			name: "exclusion takes precedence",
+			annotations: map[string]string{
+				ContainersAnnotation:        "app,istio-proxy",
+				ExcludeContainersAnnotation: "istio-proxy",
+			},
+			injected: []string{"app"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Annotations: tt.annotations},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "init"}},
+					Containers:     []corev1.Container{{Name: "app"}, {Name: "istio-proxy"}},
+					EphemeralContainers: []corev1.EphemeralContainer{
+						{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"}},
+					},
+				},
+			}
+			pod, err := injectFlyteSecretEnvVar(&core.Secret{Group: secretGroup, Key: secretKey}, pod, "pod", config.SecretConfig{})
+			assert.NoError(t, err)
+
+			var injected []string
+			for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+				if len(c.Env) > 0 {
+					injected = append(injected, c.Name)
+				}
+			}
+			for _, c := range pod.Spec.EphemeralContainers {
+				if len(c.Env) > 0 {
+					injected = append(injected, c.Name)
+				}
+			}
+			assert.Equal(t, tt.injected, injected)
+		})
+	}
+}
+
+func TestRecordPrimaryContainers(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:     "containers of the pod",
+			expected: "init,app",
+		},
+		{
+			name:        "kept on reinvocation",
+			annotations: map[string]string{PrimaryContainersAnnotation: "app"},
+			expected:    "app",
+		},
+		{
+			name:        "recorded for flyte primary container",
+			annotations: map[string]string{FlytePrimaryContainerAnnotation: "app"},
+			expected:    "init,app",
+		},
+		{
+			name:        "not recorded for included containers",
+			annotations: map[string]string{ContainersAnnotation: "app"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Annotations: tt.annotations},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "init"}},
+					Containers:     []corev1.Container{{Name: "app"}},
+				},
+			}
+			recordPrimaryContainers(pod)
+			assert.Equal(t, tt.expected, pod.Annotations[PrimaryContainersAnnotation])
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package webhook
+
+import (
+	"strings"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	flytewebhook "github.com/flyteorg/flytepropeller/pkg/webhook"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/mlp/api/log"
+)
+
+// envVarPrefix returns the configured prefix of the env var name, Flyte default prefix when it is not set
+func envVarPrefix(secretConfig config.SecretConfig) string {
+	if secretConfig.EnvVarPrefix == "" {
+		return flytewebhook.K8sDefaultEnvVarPrefix
+	}
+	return secretConfig.EnvVarPrefix
+}
+
+// envVarNameForSecret returns the env var name of the secret, following the convention Flyte Secret Manager
+// expects - {prefix}{group}_{key}, or {prefix}{key} when the group is excluded
+func envVarNameForSecret(secret *core.Secret, secretConfig config.SecretConfig) string {
+	if secretConfig.EnvVarExcludeGroup {
+		return strings.ToUpper(envVarPrefix(secretConfig) + secret.Key)
+	}
+	return strings.ToUpper(envVarPrefix(secretConfig) + secret.Group + flytewebhook.EnvVarGroupKeySeparator + secret.Key)
+}
+
+// createEnvVarsForSecret returns the env var of the secret that reads from the k8 secret created by the webhook,
+// followed by the alias named after the MLP secret when it is enabled.
+// The alias is skipped when the MLP secret name is not a valid env var name
+func createEnvVarsForSecret(secret *core.Secret, secretName string, secretConfig config.SecretConfig) []corev1.EnvVar {
+	envVar := flytewebhook.CreateEnvVarForSecret(secret)
+	envVar.Name = envVarNameForSecret(secret, secretConfig)
+	// This is where the envVar is tweak to use the created secret, instead of the secret group
+	envVar.ValueFrom.SecretKeyRef.LocalObjectReference = corev1.LocalObjectReference{
+		Name: secretName,
+	}
+	envVars := []corev1.EnvVar{envVar}
+
+	if secretConfig.EnvVarRawNameAlias {
+		if msgs := validation.IsEnvVarName(secret.Key); len(msgs) > 0 {
+			log.Warnf("skip env var alias of secret '%v': %v", secret.Key, strings.Join(msgs, ", "))
+		} else {
+			alias := *envVar.DeepCopy()
+			alias.Name = secret.Key
+			envVars = append(envVars, alias)
+		}
+	}
+	return envVars
+}
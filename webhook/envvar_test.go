@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+)
+
+func TestCreateEnvVarsForSecret(t *testing.T) {
+	optional := true
+	newEnvVar := func(name string, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "pod"},
+					Key:                  key,
+					Optional:             &optional,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		secretConfig config.SecretConfig
+		key          string
+		envVars      []corev1.EnvVar
+		prefix       string
+	}{
+		{
+			name:    "flyte default",
+			key:     "db_password",
+			envVars: []corev1.EnvVar{newEnvVar("_FSEC_TESTGROUP_DB_PASSWORD", "db_password")},
+			prefix:  "_FSEC_",
+		},
+		{
+			name:         "custom prefix without group",
+			secretConfig: config.SecretConfig{EnvVarPrefix: "SECRET_", EnvVarExcludeGroup: true},
+			key:          "db_password",
+			envVars:      []corev1.EnvVar{newEnvVar("SECRET_DB_PASSWORD", "db_password")},
+			prefix:       "SECRET_",
+		},
+		{
+			name:         "raw name alias",
+			secretConfig: config.SecretConfig{EnvVarRawNameAlias: true},
+			key:          "DB_PASSWORD",
+			envVars: []corev1.EnvVar{
+				newEnvVar("_FSEC_TESTGROUP_DB_PASSWORD", "DB_PASSWORD"),
+				newEnvVar("DB_PASSWORD", "DB_PASSWORD"),
+			},
+			prefix: "_FSEC_",
+		},
+		{
+			name:         "raw name alias skipped for invalid env var name",
+			secretConfig: config.SecretConfig{EnvVarRawNameAlias: true},
+			key:          "1password",
+			envVars:      []corev1.EnvVar{newEnvVar("_FSEC_TESTGROUP_1PASSWORD", "1password")},
+			prefix:       "_FSEC_",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &core.Secret{Group: secretGroup, Key: tt.key}
+			assert.Equal(t, tt.envVars, createEnvVarsForSecret(secret, "pod", tt.secretConfig))
+			assert.Equal(t, tt.prefix, envVarPrefix(tt.secretConfig))
+		})
+	}
+}
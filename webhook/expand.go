@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ExpandAnnotation selects the fields of structured MLP secrets to be expanded into separate secret keys and env
+	// vars, in the format of '{key}={format}:{field},{field};{key}={format}:{field}' where format is json or dotenv.
+	// The field is exposed as the secret key '{key}_{field}', and the MLP secret itself is not exposed
+	ExpandAnnotation = "dap-secret-webhook/expand"
+
+	ExpandFormatJSON   = "json"
+	ExpandFormatDotenv = "dotenv"
+)
+
+// expansion is the fields to be expanded from a structured MLP secret
+type expansion struct {
+	format string
+	fields []string
+}
+
+// parseExpansions returns the expansions of the pod annotation by the secret key. Every expanded key is expected
+// to be a secret requested by the pod
+func parseExpansions(pod *corev1.Pod, secrets []*core.Secret) (map[string]expansion, error) {
+	expansions := map[string]expansion{}
+	value := strings.TrimSpace(pod.Annotations[ExpandAnnotation])
+	if value == "" {
+		return expansions, nil
+	}
+
+	requested := map[string]bool{}
+	for _, secret := range secrets {
+		requested[secret.Key] = true
+	}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, spec, ok := strings.Cut(entry, "=")
+		format, fieldList, hasFields := strings.Cut(spec, ":")
+		key, format = strings.TrimSpace(key), strings.TrimSpace(format)
+		if !ok || !hasFields || key == "" {
+			return nil, fmt.Errorf("invalid expansion '%v' in annotation '%v', expect {key}={format}:{field},{field}", entry, ExpandAnnotation)
+		}
+		if format != ExpandFormatJSON && format != ExpandFormatDotenv {
+			return nil, fmt.Errorf("unsupported expansion format '%v' of secret '%v', expect %v or %v", format, key, ExpandFormatJSON, ExpandFormatDotenv)
+		}
+		if !requested[key] {
+			return nil, fmt.Errorf("expanded secret '%v' is not requested by the pod", key)
+		}
+		if _, ok := expansions[key]; ok {
+			return nil, fmt.Errorf("secret '%v' is expanded more than once", key)
+		}
+
+		exp := expansion{format: format}
+		for _, field := range strings.Split(fieldList, ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			if msgs := validation.IsConfigMapKey(expandedKey(key, field)); len(msgs) > 0 {
+				return nil, fmt.Errorf("field '%v' of secret '%v' is not a valid secret data key: %v", field, key, strings.Join(msgs, ", "))
+			}
+			exp.fields = append(exp.fields, field)
+		}
+		if len(exp.fields) == 0 {
+			return nil, fmt.Errorf("no field to expand from secret '%v'", key)
+		}
+		expansions[key] = exp
+	}
+
+	// an expanded field must not clash with a secret requested as is
+	for key, exp := range expansions {
+		for _, field := range exp.fields {
+			if requested[expandedKey(key, field)] {
+				return nil, fmt.Errorf("field '%v' of secret '%v' clashes with secret '%v'", field, key, expandedKey(key, field))
+			}
+		}
+	}
+	return expansions, nil
+}
+
+func expandedKey(key string, field string) string {
+	return key + "_" + field
+}
+
+// expandSecrets returns the secrets exposed to the pod, where an expanded secret is replaced by one secret per field.
+// The env var and the k8 secret key of the field are named after the secret key and the field
+func expandSecrets(secrets []*core.Secret, expansions map[string]expansion) []*core.Secret {
+	expanded := make([]*core.Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		exp, ok := expansions[secret.Key]
+		if !ok {
+			expanded = append(expanded, secret)
+			continue
+		}
+		for _, field := range exp.fields {
+			expanded = append(expanded, &core.Secret{
+				Group:            secret.Group,
+				GroupVersion:     secret.GroupVersion,
+				Key:              expandedKey(secret.Key, field),
+				MountRequirement: secret.MountRequirement,
+			})
+		}
+	}
+	return expanded
+}
+
+// resolveSecretData returns the k8 secret data of the MLP secret value, the value as is when it is not expanded
+func resolveSecretData(key string, value string, expansions map[string]expansion) (map[string][]byte, error) {
+	exp, ok := expansions[key]
+	if !ok {
+		return map[string][]byte{key: []byte(value)}, nil
+	}
+
+	var values map[string]string
+	var err error
+	switch exp.format {
+	case ExpandFormatJSON:
+		values, err = parseJSONFields(value)
+	case ExpandFormatDotenv:
+		values, err = parseDotenv(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse secret '%v' as %v: %v", key, exp.format, err)
+	}
+
+	data := map[string][]byte{}
+	for _, field := range exp.fields {
+		fieldValue, ok := values[field]
+		if !ok {
+			return nil, fmt.Errorf("field '%v' not found in secret '%v'", field, key)
+		}
+		data[expandedKey(key, field)] = []byte(fieldValue)
+	}
+	return data, nil
+}
+
+// parseJSONFields returns the top level fields of a JSON object. String values are returned as is, other values as JSON
+func parseJSONFields(value string) (map[string]string, error) {
+	raw := map[string]json.RawMessage{}
+	// the json error is not returned as it may quote the secret value
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("value is not a JSON object")
+	}
+	fields := make(map[string]string, len(raw))
+	for name, fieldValue := range raw {
+		var s string
+		if err := json.Unmarshal(fieldValue, &s); err == nil {
+			fields[name] = s
+		} else {
+			fields[name] = string(fieldValue)
+		}
+	}
+	return fields, nil
+}
+
+// parseDotenv returns the variables of a dotenv file, with lines of 'NAME=VALUE' or 'export NAME=VALUE'.
+// Blank lines and comments are skipped, and the value may be enclosed in single or double quotes
+func parseDotenv(value string) (map[string]string, error) {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(value))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, fieldValue, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid line %d, expect NAME=VALUE", lineNumber)
+		}
+		fieldValue = strings.TrimSpace(fieldValue)
+		if len(fieldValue) >= 2 && (fieldValue[0] == '"' || fieldValue[0] == '\'') && fieldValue[len(fieldValue)-1] == fieldValue[0] {
+			fieldValue = fieldValue[1 : len(fieldValue)-1]
+		}
+		fields[name] = fieldValue
+	}
+	return fields, scanner.Err()
+}
@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/dap-secret-webhook/test/mocks"
+)
+
+func TestParseExpansions(t *testing.T) {
+	requested := []*core.Secret{{Key: "gcp_sa"}, {Key: "db"}, {Key: "db_host"}}
+
+	tests := []struct {
+		name       string
+		annotation string
+		expansions map[string]expansion
+		err        error
+	}{
+		{
+			name:       "no annotation",
+			expansions: map[string]expansion{},
+		},
+		{
+			name:       "ok",
+			annotation: "gcp_sa=json:client_email, private_key; db=dotenv:PASSWORD",
+			expansions: map[string]expansion{
+				"gcp_sa": {format: ExpandFormatJSON, fields: []string{"client_email", "private_key"}},
+				"db":     {format: ExpandFormatDotenv, fields: []string{"PASSWORD"}},
+			},
+		},
+		{
+			name:       "invalid entry",
+			annotation: "gcp_sa=json",
+			err:        fmt.Errorf("invalid expansion 'gcp_sa=json' in annotation 'dap-secret-webhook/expand', expect {key}={format}:{field},{field}"),
+		},
+		{
+			name:       "unsupported format",
+			annotation: "gcp_sa=yaml:client_email",
+			err:        fmt.Errorf("unsupported expansion format 'yaml' of secret 'gcp_sa', expect json or dotenv"),
+		},
+		{
+			name:       "secret not requested",
+			annotation: "other=json:field",
+			err:        fmt.Errorf("expanded secret 'other' is not requested by the pod"),
+		},
+		{
+			name:       "invalid field",
+			annotation: "gcp_sa=json:client/email",
+			err: fmt.Errorf("field 'client/email' of secret 'gcp_sa' is not a valid secret data key: " +
+				"a valid config key must consist of alphanumeric characters, '-', '_' or '.' " +
+				"(e.g. 'key.name',  or 'KEY_NAME',  or 'key-name', regex used for validation is '[-._a-zA-Z0-9]+')"),
+		},
+		{
+			name:       "no field",
+			annotation: "gcp_sa=json:",
+			err:        fmt.Errorf("no field to expand from secret 'gcp_sa'"),
+		},
+		{
+			name:       "field clashes with requested secret",
+			annotation: "db=dotenv:host",
+			err:        fmt.Errorf("field 'host' of secret 'db' clashes with secret 'db_host'"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ExpandAnnotation: tt.annotation}}}
+			expansions, err := parseExpansions(pod, requested)
+			assert.Equal(t, tt.err, err)
+			if tt.err == nil {
+				assert.Equal(t, tt.expansions, expansions)
+			}
+		})
+	}
+}
+
+func TestResolveSecretData(t *testing.T) {
+	expansions := map[string]expansion{
+		"gcp_sa": {format: ExpandFormatJSON, fields: []string{"client_email", "port", "scopes"}},
+		"db":     {format: ExpandFormatDotenv, fields: []string{"HOST", "PASSWORD"}},
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		data  map[string][]byte
+		err   error
+	}{
+		{
+			name:  "not expanded",
+			key:   "plain",
+			value: "value",
+			data:  map[string][]byte{"plain": []byte("value")},
+		},
+		{
+			name:  "json",
+			key:   "gcp_sa",
+			value: `{"client_email":"sa@project.iam","port":5432,"scopes":["a","b"],"private_key":"key"}`,
+			data: map[string][]byte{
+				"gcp_sa_client_email": []byte("sa@project.iam"),
+				"gcp_sa_port":         []byte("5432"),
+				"gcp_sa_scopes":       []byte(`["a","b"]`),
+			},
+		},
+		{
+			name:  "dotenv",
+			key:   "db",
+			value: "# database\nexport HOST=db.local\n\nPASSWORD='p@ss=word'\nUSER=admin\n",
+			data: map[string][]byte{
+				"db_HOST":     []byte("db.local"),
+				"db_PASSWORD": []byte("p@ss=word"),
+			},
+		},
+		{
+			name:  "field not found",
+			key:   "db",
+			value: "HOST=db.local",
+			err:   fmt.Errorf("field 'PASSWORD' not found in secret 'db'"),
+		},
+		{
+			name:  "invalid json does not expose the value",
+			key:   "gcp_sa",
+			value: "s3cr3t",
+			err:   fmt.Errorf("failed to parse secret 'gcp_sa' as json: value is not a JSON object"),
+		},
+		{
+			name:  "invalid dotenv",
+			key:   "db",
+			value: "HOST=db.local\ns3cr3t",
+			err:   fmt.Errorf("failed to parse secret 'db' as dotenv: invalid line 2, expect NAME=VALUE"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := resolveSecretData(tt.key, tt.value, expansions)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.data, data)
+		})
+	}
+}
+
+func TestMutateExpandedSecret(t *testing.T) {
+	annotations, err := secrets.MarshalSecretsToMapStrings([]*core.Secret{{Group: secretGroup, Key: "gcp_sa"}})
+	assert.NoError(t, err)
+	annotations[ExpandAnnotation] = "gcp_sa=json:client_email,private_key"
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: secretGroup, Annotations: annotations},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	raw, err := json.Marshal(pod)
+	assert.NoError(t, err)
+
+	k8sClientSet := fake.NewSimpleClientset()
+	mlpClient := &mocks.MLPClient{}
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, "gcp_sa").
+		Return(`{"client_email":"sa@project.iam","private_key":"key","project_id":"project"}`, nil)
+	dapWebhook := NewDAPWebhook(k8sClientSet, nil, mlpClient, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+
+	resp := dapWebhook.Mutate(context.Background(), v1.AdmissionReview{Request: &v1.AdmissionRequest{
+		Operation: v1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	assert.True(t, resp.Allowed)
+	assert.Contains(t, string(resp.Patch), `"name":"_FSEC_TESTGROUP_GCP_SA_CLIENT_EMAIL"`)
+	assert.Contains(t, string(resp.Patch), `"name":"_FSEC_TESTGROUP_GCP_SA_PRIVATE_KEY"`)
+	assert.NotContains(t, string(resp.Patch), `"name":"_FSEC_TESTGROUP_GCP_SA"`)
+
+	secret, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"gcp_sa_client_email": []byte("sa@project.iam"),
+		"gcp_sa_private_key":  []byte("key"),
+	}, secret.Data)
+}
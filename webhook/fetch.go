@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+
+	"github.com/caraml-dev/dap-secret-webhook/client"
+)
+
+// fetchError holds the errors of all the secrets that could not be fetched, with the status code of the admission
+type fetchError struct {
+	code int32
+	errs []error
+}
+
+func (e *fetchError) Error() string {
+	if len(e.errs) == 1 {
+		return e.errs[0].Error()
+	}
+	messages := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("failed to get %d secrets: %v", len(e.errs), strings.Join(messages, "; "))
+}
+
+// fetchSecretData gets the values of the secrets of the project from MLP and resolves their expansions. At most
+// concurrency secrets are fetched at a time, or one by one when it is not positive. The data is merged in the order
+// of the secrets, and the errors of all the secrets are returned as one *fetchError
+func fetchSecretData(
+	ctx context.Context,
+	mlpClient client.MLPClient,
+	project string,
+	secrets []*core.Secret,
+	expansions map[string]expansion,
+	concurrency int,
+) (map[string][]byte, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	type result struct {
+		data map[string][]byte
+		err  error
+		code int32
+	}
+	results := make([]result, len(secrets))
+	workers := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, secret := range secrets {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, secret *core.Secret) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			// Flyte Secret 'Key' is the MLP Secret API "Name"
+			secretData, err := mlpClient.GetMLPSecretValue(ctx, project, secret.Key)
+			if err != nil {
+				results[i] = result{err: err, code: statusCodeForError(err)}
+				return
+			}
+			data, err := resolveSecretData(secret.Key, secretData, expansions)
+			if err != nil {
+				results[i] = result{err: err, code: http.StatusBadRequest}
+				return
+			}
+			results[i] = result{data: data}
+		}(i, secret)
+	}
+	wg.Wait()
+
+	data := map[string][]byte{}
+	var fetchErr *fetchError
+	for _, r := range results {
+		if r.err != nil {
+			if fetchErr == nil {
+				fetchErr = &fetchError{code: r.code}
+			}
+			// MLP failing takes precedence over MLP throttling the project, and over a malformed secret
+			if r.code > fetchErr.code {
+				fetchErr.code = r.code
+			}
+			fetchErr.errs = append(fetchErr.errs, r.err)
+			continue
+		}
+		for key, value := range r.data {
+			data[key] = value
+		}
+	}
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return data, nil
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/caraml-dev/dap-secret-webhook/test/mocks"
+)
+
+func TestFetchSecretData(t *testing.T) {
+	secrets := []*core.Secret{
+		{Group: secretGroup, Key: "a"},
+		{Group: secretGroup, Key: "b"},
+		{Group: secretGroup, Key: "db"},
+		{Group: secretGroup, Key: "d"},
+	}
+	expansions := map[string]expansion{"db": {format: ExpandFormatJSON, fields: []string{"user"}}}
+
+	tests := []struct {
+		name        string
+		values      map[string]string
+		errs        map[string]error
+		expected    map[string][]byte
+		expectedErr error
+	}{
+		{
+			name:   "ok",
+			values: map[string]string{"a": "1", "b": "2", "db": `{"user":"u"}`, "d": "4"},
+			expected: map[string][]byte{
+				"a": []byte("1"), "b": []byte("2"), "db_user": []byte("u"), "d": []byte("4"),
+			},
+		},
+		{
+			name:   "one secret failed",
+			values: map[string]string{"a": "1", "b": "2", "db": `{"user":"u"}`},
+			errs:   map[string]error{"d": fmt.Errorf("cannot find secret 'd'")},
+			expectedErr: &fetchError{
+				code: http.StatusInternalServerError,
+				errs: []error{fmt.Errorf("cannot find secret 'd'")},
+			},
+		},
+		{
+			name:   "errors of all secrets in order",
+			values: map[string]string{"a": "1", "db": `{"name":"u"}`},
+			errs: map[string]error{
+				"b": fmt.Errorf("cannot find secret 'b'"),
+				"d": fmt.Errorf("cannot find secret 'd'"),
+			},
+			expectedErr: &fetchError{
+				code: http.StatusInternalServerError,
+				errs: []error{
+					fmt.Errorf("cannot find secret 'b'"),
+					fmt.Errorf("field 'user' not found in secret 'db'"),
+					fmt.Errorf("cannot find secret 'd'"),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			running, maxRunning := 0, 0
+			mlpClient := &mocks.MLPClient{}
+			mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, mock.Anything).Return(
+				func(ctx context.Context, project string, name string) (string, error) {
+					mu.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					mu.Unlock()
+					time.Sleep(10 * time.Millisecond)
+					mu.Lock()
+					running--
+					mu.Unlock()
+					return tt.values[name], tt.errs[name]
+				})
+
+			data, err := fetchSecretData(context.Background(), mlpClient, secretGroup, secrets, expansions, 2)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expected, data)
+			assert.Equal(t, 2, maxRunning)
+			mlpClient.AssertNumberOfCalls(t, "GetMLPSecretValue", len(secrets))
+		})
+	}
+}
+
+func TestFetchErrorMessage(t *testing.T) {
+	err := &fetchError{code: http.StatusBadRequest, errs: []error{fmt.Errorf("first")}}
+	assert.Equal(t, "first", err.Error())
+	err.errs = append(err.errs, fmt.Errorf("second"))
+	assert.Equal(t, "failed to get 2 secrets: first; second", err.Error())
+	assert.Equal(t, int32(http.StatusBadRequest), statusCodeForError(err))
+}
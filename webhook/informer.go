@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	secretUtils "github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+
+	corev1 "k8s.io/api/core/v1"
+	k8errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Listers read the k8 secrets managed by the webhook and the pods with Flyte secrets from the informer caches, instead
+// of calling the api server on every admission. An object missing from the cache is read from the api server, as it
+// may not be managed by the webhook, or not be cached yet. A nil *Listers always calls the api server, and the pods
+// are listed from the api server when they are not cached
+type Listers struct {
+	secrets corelisters.SecretLister
+	pods    corelisters.PodLister
+}
+
+// NewListers starts the informer of the secrets labelled as managed by the webhook, and of the pods labelled by Flyte
+// for secret injection when withPods is set, until ctx is done. It returns once the caches are synced, or fails when
+// they are not synced within syncTimeout, e.g. as the webhook is not allowed to list or watch them
+func NewListers(
+	ctx context.Context,
+	clientSet kubernetes.Interface,
+	resync time.Duration,
+	syncTimeout time.Duration,
+	withPods bool,
+) (*Listers, error) {
+	secretFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resync,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.Set{ManagedByLabel: ManagedByValue}.String()
+		}))
+	listers := &Listers{secrets: secretFactory.Core().V1().Secrets().Lister()}
+	synced := []cache.InformerSynced{secretFactory.Core().V1().Secrets().Informer().HasSynced}
+	secretFactory.Start(ctx.Done())
+
+	if withPods {
+		podFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resync,
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = labels.Set{secretUtils.PodLabel: secretUtils.PodLabelValue}.String()
+			}))
+		listers.pods = podFactory.Core().V1().Pods().Lister()
+		synced = append(synced, podFactory.Core().V1().Pods().Informer().HasSynced)
+		podFactory.Start(ctx.Done())
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		return nil, fmt.Errorf("failed to sync informer caches within %v, check that the webhook can list and watch "+
+			"secrets and pods, or disable K8S_INFORMER_ENABLED", syncTimeout)
+	}
+	return listers, nil
+}
+
+// getSecret returns a copy of the secret from the cache, or from the api server when it is not cached
+func (l *Listers) getSecret(ctx context.Context, clientSet kubernetes.Interface, namespace string, name string) (*corev1.Secret, error) {
+	if l != nil {
+		k8secret, err := l.secrets.Secrets(namespace).Get(name)
+		if err == nil {
+			return k8secret.DeepCopy(), nil
+		}
+		if !k8errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return clientSet.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// listPods returns the pods of the namespace matching the selector, from the cache when the pods are cached.
+// The pods must not be modified, as they are shared with the cache
+func (l *Listers) listPods(ctx context.Context, clientSet kubernetes.Interface, namespace string, selector labels.Set) ([]*corev1.Pod, error) {
+	if l != nil && l.pods != nil {
+		return l.pods.Pods(namespace).List(selector.AsSelector())
+	}
+	podList, err := clientSet.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*corev1.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	return pods, nil
+}
+
+// updateK8Secret applies update to the existing secret managed by the webhook and writes it, update returns false when
+// there is nothing to write. The secret is read from the cache first, and from the api server when the write conflicts
+// as the cached secret was outdated
+func updateK8Secret(
+	ctx context.Context,
+	clientSet kubernetes.Interface,
+	listers *Listers,
+	namespace string,
+	name string,
+	update func(existing *corev1.Secret) bool,
+) (bool, error) {
+	for {
+		existing, err := listers.getSecret(ctx, clientSet, namespace, name)
+		if err != nil {
+			return false, err
+		}
+		if !isManagedSecret(existing) {
+			return false, newNotManagedError(existing)
+		}
+		if !update(existing) {
+			return false, nil
+		}
+		_, err = clientSet.CoreV1().Secrets(namespace).Update(ctx, existing, metav1.UpdateOptions{})
+		if k8errors.IsConflict(err) && listers != nil {
+			listers = nil
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to update mlpSecret: %v", err)
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	k8errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestListers(t *testing.T, objects ...runtime.Object) (*fake.Clientset, *Listers) {
+	k8sClientSet := fake.NewSimpleClientset(objects...)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	listers, err := NewListers(ctx, k8sClientSet, 0, time.Second, true)
+	assert.NoError(t, err)
+	// only the calls made after the caches are synced are recorded
+	k8sClientSet.ClearActions()
+	return k8sClientSet, listers
+}
+
+func TestListers(t *testing.T) {
+	managed := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "managed", Namespace: secretGroup, Labels: map[string]string{ManagedByLabel: ManagedByValue},
+	}}
+	user := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "user", Namespace: secretGroup, Labels: map[string]string{"app": "user"},
+	}}
+	flytePod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "flyte", Namespace: secretGroup, Labels: map[string]string{secrets.PodLabel: secrets.PodLabelValue},
+	}}
+	otherPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: secretGroup}}
+	k8sClientSet, listers := newTestListers(t, managed, user, flytePod, otherPod)
+
+	// the managed secret is cached, the others are read from the api server
+	got, err := listers.getSecret(context.Background(), k8sClientSet, secretGroup, "managed")
+	assert.NoError(t, err)
+	assert.Equal(t, managed, got)
+	assert.Empty(t, k8sClientSet.Actions())
+
+	got, err = listers.getSecret(context.Background(), k8sClientSet, secretGroup, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, user, got)
+	_, err = listers.getSecret(context.Background(), k8sClientSet, secretGroup, "missing")
+	assert.True(t, k8errors.IsNotFound(err))
+	assert.Equal(t, 2, len(k8sClientSet.Actions()))
+
+	// only the pods with Flyte secrets are cached
+	k8sClientSet.ClearActions()
+	pods, err := listers.listPods(context.Background(), k8sClientSet, metav1.NamespaceAll, labels.Set{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*corev1.Pod{flytePod}, pods)
+	assert.Empty(t, k8sClientSet.Actions())
+
+	// a nil *Listers calls the api server
+	var noListers *Listers
+	pods, err = noListers.listPods(context.Background(), k8sClientSet, secretGroup, labels.Set{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pods))
+	got, err = noListers.getSecret(context.Background(), k8sClientSet, secretGroup, "managed")
+	assert.NoError(t, err)
+	assert.Equal(t, managed, got)
+	assert.Equal(t, 2, len(k8sClientSet.Actions()))
+}
+
+func TestListersWithoutPods(t *testing.T) {
+	flytePod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "flyte", Namespace: secretGroup, Labels: map[string]string{secrets.PodLabel: secrets.PodLabelValue},
+	}}
+	k8sClientSet := fake.NewSimpleClientset(flytePod)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	listers, err := NewListers(ctx, k8sClientSet, 0, time.Second, false)
+	assert.NoError(t, err)
+	k8sClientSet.ClearActions()
+
+	// the pods are not cached, they are read from the api server
+	pods, err := listers.listPods(context.Background(), k8sClientSet, secretGroup, labels.Set{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pods))
+	assert.Equal(t, 1, len(k8sClientSet.Actions()))
+}
+
+func TestListersSyncTimeout(t *testing.T) {
+	// the webhook is not allowed to list the secrets, the informer retries forever
+	k8sClientSet := fake.NewSimpleClientset()
+	k8sClientSet.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8errors.NewForbidden(corev1.Resource("secrets"), "", fmt.Errorf("rbac"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	_, err := NewListers(ctx, k8sClientSet, 0, 200*time.Millisecond, false)
+	assert.EqualError(t, err, "failed to sync informer caches within 200ms, check that the webhook can list and watch "+
+		"secrets and pods, or disable K8S_INFORMER_ENABLED")
+}
+
+func TestCreateOrUpdateK8SecretCached(t *testing.T) {
+	newSecret := func(data string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "pod", Namespace: secretGroup, Labels: map[string]string{ManagedByLabel: ManagedByValue},
+			},
+			Data: map[string][]byte{data: []byte(data)},
+		}
+	}
+	verbs := func(actions []k8stesting.Action) []string {
+		got := make([]string, 0, len(actions))
+		for _, action := range actions {
+			got = append(got, action.GetVerb())
+		}
+		return got
+	}
+
+	tests := []struct {
+		name      string
+		existing  *corev1.Secret
+		conflicts int
+		expected  []string
+	}{
+		{
+			name:     "create without reading the secret",
+			expected: []string{"create"},
+		},
+		{
+			name:     "update the cached secret",
+			existing: newSecret("stale"),
+			expected: []string{"create", "update"},
+		},
+		{
+			name:      "read the secret from the api server when the cached one is outdated",
+			existing:  newSecret("stale"),
+			conflicts: 1,
+			expected:  []string{"create", "update", "get", "update"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if tt.existing != nil {
+				objects = append(objects, tt.existing)
+			}
+			k8sClientSet, listers := newTestListers(t, objects...)
+			conflicts := tt.conflicts
+			k8sClientSet.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicts > 0 {
+					conflicts--
+					return true, nil, k8errors.NewConflict(corev1.Resource("secrets"), "pod", fmt.Errorf("changed"))
+				}
+				return false, nil, nil
+			})
+
+			assert.NoError(t, createOrUpdateK8Secret(context.Background(), k8sClientSet, listers, newSecret("new")))
+			assert.Equal(t, tt.expected, verbs(k8sClientSet.Actions()))
+
+			got, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod", metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, map[string][]byte{"new": []byte("new")}, got.Data)
+		})
+	}
+}
+
+func TestDeleteK8SecretCached(t *testing.T) {
+	existing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "pod", Namespace: secretGroup, Labels: map[string]string{ManagedByLabel: ManagedByValue},
+	}}
+	k8sClientSet, listers := newTestListers(t, existing)
+
+	assert.NoError(t, deleteK8Secret(context.Background(), k8sClientSet, listers, secretGroup, "pod"))
+	assert.Equal(t, 1, len(k8sClientSet.Actions()))
+	assert.Equal(t, "delete", k8sClientSet.Actions()[0].GetVerb())
+
+	// the informer catches up with the deletion
+	assert.Eventually(t, func() bool {
+		_, err := listers.secrets.Secrets(secretGroup).Get("pod")
+		return k8errors.IsNotFound(err)
+	}, time.Second, 10*time.Millisecond)
+}
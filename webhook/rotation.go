@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	secretUtils "github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+
+	corev1 "k8s.io/api/core/v1"
+	k8errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/caraml-dev/dap-secret-webhook/client"
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/mlp/api/log"
+	"github.com/caraml-dev/mlp/api/pkg/instrumentation/metrics"
+)
+
+const SecretRotationsTotal string = "flyte_dsw_secret_rotations_total"
+
+var SecretRotationsTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: SecretRotationsTotal,
+	Help: "Number of pod secret updated with rotated value from MLP",
+},
+	[]string{"project", "status"},
+)
+
+// SecretRefresher periodically re-reads the secret values from MLP for live pods that opted in with
+// the rotation annotation, and updates the pod secret when a value has changed.
+// Only file mounted secrets pick up the new value, env var are read once by the container on start.
+type SecretRefresher struct {
+	k8sClientSet kubernetes.Interface
+	listers      *Listers
+	mlpClient    client.MLPClient
+	cfg          config.RotationConfig
+	secretConfig config.SecretConfig
+}
+
+func NewSecretRefresher(
+	k8sClientSet kubernetes.Interface,
+	listers *Listers,
+	mlpClient client.MLPClient,
+	cfg config.RotationConfig,
+	secretConfig config.SecretConfig,
+) *SecretRefresher {
+	return &SecretRefresher{
+		k8sClientSet: k8sClientSet,
+		listers:      listers,
+		mlpClient:    mlpClient,
+		cfg:          cfg,
+		secretConfig: secretConfig,
+	}
+}
+
+// Run refreshes the pod secrets every interval until the context is done
+func (r *SecretRefresher) Run(ctx context.Context) {
+	log.Infof("refreshing secrets of pods with annotation '%v' every %v", r.cfg.Annotation, r.cfg.Interval)
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.refresh(ctx); err != nil {
+				log.Errorf("failed to refresh secrets: %v", err)
+			}
+		}
+	}
+}
+
+// refresh updates the secret of every live pod that opted in for rotation. Failure of a pod does not stop the others
+func (r *SecretRefresher) refresh(ctx context.Context) error {
+	pods, err := r.listers.listPods(ctx, r.k8sClientSet, metav1.NamespaceAll,
+		labels.Set{secretUtils.PodLabel: secretUtils.PodLabelValue})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	// secret shared by the pods of an execution node is only refreshed once
+	refreshed := map[string]bool{}
+	for _, pod := range pods {
+		if pod.Annotations[r.cfg.Annotation] != "true" || !isPodLive(pod) {
+			continue
+		}
+		secrets, err := secretUtils.UnmarshalStringMapToSecrets(pod.GetAnnotations())
+		if err != nil {
+			log.Errorf("failed to refresh secret of pod: '%v' in namespace: '%v': %v", pod.Name, pod.Namespace, err)
+			continue
+		}
+		secretName := secretNameForPod(pod, secrets, r.secretConfig)
+		if refreshed[pod.Namespace+"/"+secretName] {
+			continue
+		}
+		refreshed[pod.Namespace+"/"+secretName] = true
+
+		rotated, err := r.refreshPodSecret(ctx, pod, secretName, secrets)
+		if err != nil {
+			log.Errorf("failed to refresh secret of pod: '%v' in namespace: '%v': %v", pod.Name, pod.Namespace, err)
+		}
+		if rotated || err != nil {
+			SecretRotationsTotalMetrics.WithLabelValues(pod.Namespace, metrics.GetStatusString(err == nil)).Inc()
+		}
+	}
+	return nil
+}
+
+// refreshPodSecret re-reads the values of the pod secrets from MLP and returns true when the secret is updated
+func (r *SecretRefresher) refreshPodSecret(
+	ctx context.Context,
+	pod *corev1.Pod,
+	secretName string,
+	secrets []*core.Secret,
+) (bool, error) {
+	k8secret, err := r.listers.getSecret(ctx, r.k8sClientSet, pod.Namespace, secretName)
+	if err != nil {
+		if k8errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	// a secret of the same name created by someone else is never overwritten
+	if !isManagedSecret(k8secret) {
+		return false, nil
+	}
+
+	expansions, err := parseExpansions(pod, secrets)
+	if err != nil {
+		return false, err
+	}
+	ctx, stale := client.WithStaleTracker(ctx)
+	data, err := fetchSecretData(ctx, r.mlpClient, pod.Namespace, secrets, expansions, r.secretConfig.FetchConcurrency)
+	if err != nil {
+		return false, err
+	}
+	// a stale value may be older than the one in the secret, it is refreshed on the next interval instead
+	if staleNames := stale.Names(); len(staleNames) > 0 {
+		return false, fmt.Errorf("mlp served possibly stale secrets %v", staleNames)
+	}
+	templates, err := parseTemplates(pod, expandSecrets(secrets, expansions))
+	if err != nil {
+		return false, err
+	}
+	rendered, err := renderTemplates(templates, data)
+	if err != nil {
+		return false, err
+	}
+	for key, value := range rendered {
+		data[key] = value
+	}
+
+	if !isDataChanged(k8secret.Data, data) {
+		return false, nil
+	}
+	k8secret.Data = data
+	if _, err := r.k8sClientSet.CoreV1().Secrets(pod.Namespace).Update(ctx, k8secret, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to update mlpSecret: %v", err)
+	}
+	log.Infof("rotated k8 secret: '%v' in namespace: '%v'", k8secret.Name, k8secret.Namespace)
+	return true, nil
+}
+
+// isPodLive returns false for pods that have terminated, as their secret is no longer read
+func isPodLive(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp == nil &&
+		pod.Status.Phase != corev1.PodSucceeded &&
+		pod.Status.Phase != corev1.PodFailed
+}
+
+func isDataChanged(current map[string][]byte, latest map[string][]byte) bool {
+	if len(current) != len(latest) {
+		return true
+	}
+	for key, value := range latest {
+		if currentValue, ok := current[key]; !ok || !bytes.Equal(currentValue, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/dap-secret-webhook/test/mocks"
+)
+
+func TestSecretRefresher(t *testing.T) {
+	annotations, err := secrets.MarshalSecretsToMapStrings([]*core.Secret{{Group: secretGroup, Key: secretKey}})
+	assert.NoError(t, err)
+
+	newPod := func(name string, rotate bool, phase corev1.PodPhase) *corev1.Pod {
+		podAnnotations := map[string]string{}
+		for k, v := range annotations {
+			podAnnotations[k] = v
+		}
+		if rotate {
+			podAnnotations["dap-secret-webhook/rotate"] = "true"
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   secretGroup,
+				Labels:      map[string]string{secrets.PodLabel: secrets.PodLabelValue},
+				Annotations: podAnnotations,
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+	newSecret := func(name string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: secretGroup,
+				Labels:    map[string]string{ManagedByLabel: ManagedByValue},
+			},
+			Data: map[string][]byte{secretKey: []byte("old_data")},
+		}
+	}
+	unmanagedSecret := newSecret("not-managed")
+	unmanagedSecret.Labels = nil
+
+	k8sClientSet := fake.NewSimpleClientset(
+		newPod("rotated", true, corev1.PodRunning), newSecret("rotated"),
+		newPod("not-opted-in", false, corev1.PodRunning), newSecret("not-opted-in"),
+		newPod("completed", true, corev1.PodSucceeded), newSecret("completed"),
+		// secret may be deleted before the pod is gone
+		newPod("without-secret", true, corev1.PodRunning),
+		// secret of the same name created by someone else
+		newPod("not-managed", true, corev1.PodRunning), unmanagedSecret,
+	)
+	mlpClient := &mocks.MLPClient{}
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("new_data", nil)
+
+	refresher := NewSecretRefresher(k8sClientSet, nil, mlpClient, config.RotationConfig{
+		Interval:   time.Minute,
+		Annotation: "dap-secret-webhook/rotate",
+	}, config.SecretConfig{})
+	assert.NoError(t, refresher.refresh(context.Background()))
+
+	expected := map[string]string{
+		"rotated":      "new_data",
+		"not-opted-in": "old_data",
+		"completed":    "old_data",
+		"not-managed":  "old_data",
+	}
+	for name, value := range expected {
+		secret, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, value, string(secret.Data[secretKey]), name)
+	}
+	// MLP is only called for the live pods that opted in and have a secret managed by the webhook
+	mlpClient.AssertNumberOfCalls(t, "GetMLPSecretValue", 1)
+}
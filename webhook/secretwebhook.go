@@ -3,9 +3,12 @@ package webhook
 import (
 	"context"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -22,8 +25,10 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
+	"github.com/caraml-dev/dap-secret-webhook/audit"
 	"github.com/caraml-dev/dap-secret-webhook/client"
 	"github.com/caraml-dev/dap-secret-webhook/config"
 	"github.com/caraml-dev/mlp/api/log"
@@ -32,6 +37,25 @@ import (
 
 const RequestsTotal string = "flyte_dsw_webhook_requests_total"
 
+const (
+	// ManagedByLabel marks the k8 secrets created by the webhook. Secrets without it are never modified or deleted by the webhook
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "dap-secret-webhook"
+
+	// PodAnnotation, AdmissionUIDAnnotation and CreatedAtAnnotation record the admission request that created the k8 secret.
+	// The pod uid is not assigned yet on pod creation, hence the admission uid is recorded instead
+	PodAnnotation          = "dap-secret-webhook/pod"
+	AdmissionUIDAnnotation = "dap-secret-webhook/admission-uid"
+	CreatedAtAnnotation    = "dap-secret-webhook/created-at"
+
+	// StaleSecretsAnnotation lists the secrets of the pod that may be stale, as they were served from the last known
+	// good value while MLP was unavailable
+	StaleSecretsAnnotation = "dap-secret-webhook/stale-secrets"
+
+	// EphemeralContainersSubResource is updated when a debug container is attached to a running pod
+	EphemeralContainersSubResource = "ephemeralcontainers"
+)
+
 var RequestsTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: RequestsTotal,
 	Help: "Number of request processed by Webhook",
@@ -39,38 +63,77 @@ var RequestsTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
 	[]string{"project", "status", "operation"},
 )
 
+// admissionTimeoutMargin is left of the webhook timeout to respond to the api server before it times out
+const admissionTimeoutMargin = time.Second
+
 type DAPWebhook struct {
 	k8sClientSet kubernetes.Interface
+	// listers cache the k8 secrets and pods read on admission, the api server is called instead when it is nil
+	listers      *Listers
 	mlpClient    client.MLPClient
 	decoder      runtime.Decoder
+	auditSink    audit.Sink
+	secretConfig config.SecretConfig
+	// timeout of the api server calling the webhook, the admission has no deadline when it is 0
+	timeout time.Duration
 }
 
 func NewDAPWebhook(
 	k8sClientSet kubernetes.Interface,
+	listers *Listers,
 	mlpClient client.MLPClient,
 	decoder runtime.Decoder,
+	auditSink audit.Sink,
+	secretConfig config.SecretConfig,
+	timeout time.Duration,
 ) DAPWebhook {
 	return DAPWebhook{
 		k8sClientSet: k8sClientSet,
+		listers:      listers,
 		mlpClient:    mlpClient,
 		decoder:      decoder,
+		auditSink:    auditSink,
+		secretConfig: secretConfig,
+		timeout:      timeout,
+	}
+}
+
+// admissionContext returns ctx with a deadline of the webhook timeout minus a safety margin.
+// Half of the timeout is used instead when the timeout is shorter than twice the margin
+func (pm *DAPWebhook) admissionContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if pm.timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	timeout := pm.timeout - admissionTimeoutMargin
+	if timeout < pm.timeout/2 {
+		timeout = pm.timeout / 2
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 /*
 Mutate is intended to ingrate Flyte Secret with MLP SecretAPIClient.
 On 'Create' Pod invocation, it will create a secret and append env var to the pod.
 On 'Delete' Pod invocation, it will delete the secret
+On 'Update' of the ephemeral containers, it will append the env var to the debug containers, the secret is already created
 
 The secret name is created with pod name, with secret key as Flyte Secret Key
 The secret value is retrieved from MLP with Flyte Secret Key as the key
+When the secret is shared per execution, the secret name is created with the Flyte execution and node id instead,
+and the secret is only deleted with the last pod of the node
 
 The env var created follows the same convention Flyte expects - {prefix}-{group}-{key}
-however the env var value is tweak to read from the above created secret
+however the env var value is tweak to read from the above created secret. The prefix, group and an alias named
+after the MLP secret are configurable
+
+# Flyte Secret Group is ignored and only key is used
 
-Flyte Secret Group is ignored and only key is used
+The calls to MLP and k8 are abandoned when ctx is done, i.e. the api server gave up on the request, or shortly
+before the webhook timeout, so that the webhook can respond with the error instead of timing out
 */
-func (pm *DAPWebhook) Mutate(ar v1.AdmissionReview) *v1.AdmissionResponse {
+func (pm *DAPWebhook) Mutate(ctx context.Context, ar v1.AdmissionReview) *v1.AdmissionResponse {
+	ctx, cancel := pm.admissionContext(ctx)
+	defer cancel()
 
 	pod := &corev1.Pod{}
 	var admissionResponse *v1.AdmissionResponse
@@ -93,7 +156,7 @@ func (pm *DAPWebhook) Mutate(ar v1.AdmissionReview) *v1.AdmissionResponse {
 			admissionResponse = toAdmissionResponse(http.StatusBadRequest, err)
 		} else {
 			log.Infof("received create request for pod: '%v' in namespace: '%v'", pod.Name, pod.Namespace)
-			admissionResponse = pm.mutatePodAndCreateSecret(ar, pod)
+			admissionResponse = pm.mutatePodAndCreateSecret(ctx, ar, pod)
 		}
 	} else if ar.Request.Operation == v1.Delete {
 		_, _, err = pm.decoder.Decode(ar.Request.OldObject.Raw, nil, pod)
@@ -101,7 +164,15 @@ func (pm *DAPWebhook) Mutate(ar v1.AdmissionReview) *v1.AdmissionResponse {
 			admissionResponse = toAdmissionResponse(http.StatusBadRequest, err)
 		} else {
 			log.Infof("received delete request for pod: '%v' in namespace: '%v'", pod.Name, pod.Namespace)
-			admissionResponse = pm.deleteSecret(ar, pod)
+			admissionResponse = pm.deleteSecret(ctx, ar, pod)
+		}
+	} else if ar.Request.Operation == v1.Update && ar.Request.SubResource == EphemeralContainersSubResource {
+		_, _, err = pm.decoder.Decode(ar.Request.Object.Raw, nil, pod)
+		if err != nil {
+			admissionResponse = toAdmissionResponse(http.StatusBadRequest, err)
+		} else {
+			log.Infof("received ephemeral containers update request for pod: '%v' in namespace: '%v'", pod.Name, pod.Namespace)
+			admissionResponse = pm.mutateEphemeralContainers(ar, pod)
 		}
 	} else {
 		// should never come into this block, by the webhook config's rule
@@ -127,52 +198,177 @@ func (pm *DAPWebhook) Mutate(ar v1.AdmissionReview) *v1.AdmissionResponse {
 }
 
 // mutatePodAndCreateSecret inject flyte secrets to the pod as env var, which value are retrieved from mlp client
-func (pm *DAPWebhook) mutatePodAndCreateSecret(ar v1.AdmissionReview, pod *corev1.Pod) *v1.AdmissionResponse {
+func (pm *DAPWebhook) mutatePodAndCreateSecret(ctx context.Context, ar v1.AdmissionReview, pod *corev1.Pod) (resp *v1.AdmissionResponse) {
+	var secrets []*core.Secret
+	defer func(pod *corev1.Pod) {
+		keys := make([]string, 0, len(secrets))
+		for _, secret := range secrets {
+			keys = append(keys, secret.Key)
+		}
+		pm.writeAudit(ar, pod, keys, resp)
+	}(pod)
+
 	// get Flyte Secrets from annotation that are injected by Flyte Propeller
 	secrets, err := secretUtils.UnmarshalStringMapToSecrets(pod.GetAnnotations())
 	if err != nil {
 		return toAdmissionResponse(http.StatusInternalServerError, err)
 	}
 
-	// k8 secret to be created for the Flyte Task, name of secret will be pod name
+	// k8 secret to be created for the Flyte Task, name of secret will be pod name, or the execution node when shared
+	secretName := secretNameForPod(pod, secrets, pm.secretConfig)
+	shared := secretName != pod.Name
 	k8secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pod.Name,
+			Name:      secretName,
 			Namespace: pod.Namespace,
+			Labels: map[string]string{
+				ManagedByLabel: ManagedByValue,
+			},
+			Annotations: map[string]string{
+				PodAnnotation:          pod.Name,
+				AdmissionUIDAnnotation: string(ar.Request.UID),
+				CreatedAtAnnotation:    time.Now().UTC().Format(time.RFC3339),
+			},
 		},
 		Data: map[string][]byte{},
 		Type: corev1.SecretTypeOpaque,
 	}
 
-	// The k8 secret will always be created with a unique id and deleted after
-	// Flyte Secret 'Key' is the MLP Secret API "Name"
-	for _, secret := range secrets {
+	// structured secrets are exposed as one secret per selected field
+	expansions, err := parseExpansions(pod, secrets)
+	if err != nil {
+		return toAdmissionResponse(http.StatusBadRequest, err)
+	}
+	exposedSecrets := expandSecrets(secrets, expansions)
+	// config files rendered from the secrets, the templates are checked against the exposed secrets before any is fetched
+	templates, err := parseTemplates(pod, exposedSecrets)
+	if err != nil {
+		return toAdmissionResponse(http.StatusBadRequest, err)
+	}
+
+	// the sidecars added by the webhooks called after this one are not injected on reinvocation
+	recordPrimaryContainers(pod)
+	for _, secret := range exposedSecrets {
 		// Inject Flyte secrets as env var to pod, the secretRef is modified here
-		pod, err = injectFlyteSecretEnvVar(secret, pod)
+		pod, err = injectFlyteSecretEnvVar(secret, pod, secretName, pm.secretConfig)
 		if err != nil {
 			return toAdmissionResponse(http.StatusInternalServerError, err)
 		}
+	}
+	pod = mountTemplates(pod, templates, secretName)
 
-		secretData, err := pm.mlpClient.GetMLPSecretValue(pod.Namespace, secret.Key)
+	log.Infof("injecting %d secrets to pod: '%v' in namespace: '%v'", len(exposedSecrets), pod.Name, pod.Namespace)
+
+	if shared {
+		k8secret.OwnerReferences = sharedOwnerReferences(pod)
+		existing, err := getReusableSharedSecret(ctx, pm.k8sClientSet, pm.listers, pod.Namespace, secretName, exposedSecrets)
 		if err != nil {
-			return toAdmissionResponse(http.StatusInternalServerError, err)
+			return toAdmissionResponse(statusCodeForError(err), err)
+		}
+		if existing != nil {
+			k8secret.Data = existing.Data
 		}
-		k8secret.Data[secret.Key] = []byte(secretData)
 	}
 
-	log.Infof("injecting %d secrets to pod: '%v' in namespace: '%v'", len(secrets), pod.Name, pod.Namespace)
+	// The k8 secret will always be created with a unique id and deleted after.
+	// The secrets already in the reused shared secret are not fetched again
+	var missing []*core.Secret
+	for _, secret := range secrets {
+		if !hasSecretData(k8secret.Data, expandSecrets([]*core.Secret{secret}, expansions)) {
+			missing = append(missing, secret)
+		}
+	}
+	ctx, stale := client.WithStaleTracker(ctx)
+	data, err := fetchSecretData(ctx, pm.mlpClient, pod.Namespace, missing, expansions, pm.secretConfig.FetchConcurrency)
+	if err != nil {
+		return toAdmissionResponse(statusCodeForError(err), err)
+	}
+	for key, value := range data {
+		k8secret.Data[key] = value
+	}
+	if staleNames := stale.Names(); len(staleNames) > 0 {
+		log.Warnf("injecting possibly stale secrets %v to pod: '%v' in namespace: '%v'", staleNames, pod.Name, pod.Namespace)
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[StaleSecretsAnnotation] = strings.Join(staleNames, ",")
+	} else if len(missing) == len(secrets) {
+		// every secret is fresh, the pod may have been marked by an earlier call, e.g. on reinvocation
+		delete(pod.Annotations, StaleSecretsAnnotation)
+	}
 
-	err = createK8Secret(pm.k8sClientSet, k8secret)
+	rendered, err := renderTemplates(templates, k8secret.Data)
+	if err != nil {
+		return toAdmissionResponse(http.StatusBadRequest, err)
+	}
+	for key, value := range rendered {
+		k8secret.Data[key] = value
+	}
+
+	if shared {
+		err = createOrReferenceSharedK8Secret(ctx, pm.k8sClientSet, pm.listers, k8secret)
+	} else {
+		err = createOrUpdateK8Secret(ctx, pm.k8sClientSet, pm.listers, k8secret)
+	}
+	if err != nil {
+		return toAdmissionResponse(statusCodeForError(err), err)
+	}
+
+	return toPatchResponse(ar, pod)
+}
+
+// mutateEphemeralContainers inject flyte secrets and the rendered templates to the debug containers attached to the
+// pod. The secret was created along with the pod, so MLP is not called. Containers that already have the env var or
+// mount are left unchanged
+func (pm *DAPWebhook) mutateEphemeralContainers(ar v1.AdmissionReview, pod *corev1.Pod) (resp *v1.AdmissionResponse) {
+	var secrets []*core.Secret
+	defer func(pod *corev1.Pod) {
+		keys := make([]string, 0, len(secrets))
+		for _, secret := range secrets {
+			keys = append(keys, secret.Key)
+		}
+		pm.writeAudit(ar, pod, keys, resp)
+	}(pod)
+
+	secrets, err := secretUtils.UnmarshalStringMapToSecrets(pod.GetAnnotations())
 	if err != nil {
 		return toAdmissionResponse(http.StatusInternalServerError, err)
 	}
 
+	expansions, err := parseExpansions(pod, secrets)
+	if err != nil {
+		return toAdmissionResponse(http.StatusBadRequest, err)
+	}
+
+	exposedSecrets := expandSecrets(secrets, expansions)
+	templates, err := parseTemplates(pod, exposedSecrets)
+	if err != nil {
+		return toAdmissionResponse(http.StatusBadRequest, err)
+	}
+
+	secretName := secretNameForPod(pod, secrets, pm.secretConfig)
+	for _, secret := range exposedSecrets {
+		pod, err = injectFlyteSecretEnvVar(secret, pod, secretName, pm.secretConfig)
+		if err != nil {
+			return toAdmissionResponse(http.StatusInternalServerError, err)
+		}
+	}
+	pod = mountEphemeralTemplates(pod, templates, secretName)
+	return toPatchResponse(ar, pod)
+}
+
+// toPatchResponse returns an AdmissionResponse with the json patch from the request object to the mutated pod
+func toPatchResponse(ar v1.AdmissionReview, pod *corev1.Pod) *v1.AdmissionResponse {
 	marshalled, err := json.Marshal(pod)
 	if err != nil {
 		return toAdmissionResponse(http.StatusInternalServerError, err)
 	}
 
 	response := admission.PatchResponseFromRaw(ar.Request.Object.Raw, marshalled)
+	// pod that is already mutated, e.g. on reinvocation, has nothing to patch
+	if len(response.Patches) == 0 {
+		return &v1.AdmissionResponse{Allowed: true}
+	}
 	adminResponse := &response.AdmissionResponse
 	adminResponse.Patch, err = json.Marshal(response.Patches)
 	if err != nil {
@@ -182,14 +378,60 @@ func (pm *DAPWebhook) mutatePodAndCreateSecret(ar v1.AdmissionReview, pod *corev
 }
 
 // deleteSecret deletes the secret that was created along with the pod. No modification to pod is required
-func (pm *DAPWebhook) deleteSecret(_ v1.AdmissionReview, pod *corev1.Pod) *v1.AdmissionResponse {
-	// the secrets are created with podName in the same namespace
-	if err := deleteK8Secret(pm.k8sClientSet, pod.Namespace, pod.Name); err != nil {
+func (pm *DAPWebhook) deleteSecret(ctx context.Context, ar v1.AdmissionReview, pod *corev1.Pod) (resp *v1.AdmissionResponse) {
+	defer func() {
+		pm.writeAudit(ar, pod, nil, resp)
+	}()
+
+	// the secrets are created with podName in the same namespace, or shared by the pods of the execution node
+	secretName := pod.Name
+	if secrets, err := secretUtils.UnmarshalStringMapToSecrets(pod.GetAnnotations()); err == nil {
+		secretName = secretNameForPod(pod, secrets, pm.secretConfig)
+	}
+	if secretName != pod.Name {
+		// the shared secret may be referenced by a pod being admitted, it is garbage collected with the owners
+		log.Infof("keeping shared k8 secret: '%v' in namespace: '%v' until its owners are deleted", secretName, pod.Namespace)
+		return &v1.AdmissionResponse{Allowed: true}
+	}
+
+	if err := deleteK8Secret(ctx, pm.k8sClientSet, pm.listers, pod.Namespace, pod.Name); err != nil {
 		return toAdmissionResponse(http.StatusInternalServerError, err)
 	}
 	return &v1.AdmissionResponse{Allowed: true}
 }
 
+// writeAudit records the keys requested by the pod and the admission decision. Secret values are never recorded.
+// Failure to write the record is logged and does not affect the admission response
+func (pm *DAPWebhook) writeAudit(ar v1.AdmissionReview, pod *corev1.Pod, keys []string, resp *v1.AdmissionResponse) {
+	if pm.auditSink == nil {
+		return
+	}
+	record := audit.Record{
+		Timestamp:      time.Now().UTC(),
+		AdmissionUID:   string(ar.Request.UID),
+		Operation:      string(ar.Request.Operation),
+		Username:       ar.Request.UserInfo.Username,
+		UserUID:        ar.Request.UserInfo.UID,
+		UserGroups:     ar.Request.UserInfo.Groups,
+		Namespace:      pod.Namespace,
+		Pod:            pod.Name,
+		ServiceAccount: pod.Spec.ServiceAccountName,
+		// MLP project is the same as the namespace of the pod
+		Project:  pod.Namespace,
+		Keys:     keys,
+		Decision: audit.DecisionAllowed,
+	}
+	if resp == nil || !resp.Allowed {
+		record.Decision = audit.DecisionDenied
+		if resp != nil && resp.Result != nil {
+			record.Reason = resp.Result.Message
+		}
+	}
+	if err := pm.auditSink.Write(record); err != nil {
+		log.Errorf("failed to write audit record for pod: '%v' in namespace: '%v': %v", pod.Name, pod.Namespace, err)
+	}
+}
+
 // toAdmissionResponse return an AdmissionResponse with the error.
 func toAdmissionResponse(code int32, err error) *v1.AdmissionResponse {
 	ar := admission.Errored(code, err).AdmissionResponse
@@ -197,9 +439,14 @@ func toAdmissionResponse(code int32, err error) *v1.AdmissionResponse {
 }
 
 // injectFlyteSecretEnvVar inject secret as env var onto pod using flyte library which holds the convention
-// of env var for the secrets to be loaded into FlyteContext. Modification is done only to the "ValueFrom" of the
-// env var, so that it reads
-func injectFlyteSecretEnvVar(secret *core.Secret, p *corev1.Pod) (newP *corev1.Pod, err error) {
+// of env var for the secrets to be loaded into FlyteContext. Modification is done to the "ValueFrom" of the
+// env var, so that it reads from the k8 secret created by the webhook, and to the name when it is configured
+func injectFlyteSecretEnvVar(
+	secret *core.Secret,
+	p *corev1.Pod,
+	secretName string,
+	secretConfig config.SecretConfig,
+) (newP *corev1.Pod, err error) {
 	// secret group is expected to be empty
 	if len(secret.Key) == 0 {
 		return nil, fmt.Errorf("webhook require secretkey to be set. "+
@@ -210,21 +457,22 @@ func injectFlyteSecretEnvVar(secret *core.Secret, p *corev1.Pod) (newP *corev1.P
 	case core.Secret_ANY:
 		fallthrough
 	case core.Secret_ENV_VAR:
-		envVar := flytewebhook.CreateEnvVarForSecret(secret)
-		// This is where the envVar is tweak to use pod name as name of the secret
-		envVar.ValueFrom.SecretKeyRef.LocalObjectReference = corev1.LocalObjectReference{
-			Name: p.Name,
+		// only the containers selected by the pod annotations are injected
+		selector := newContainerSelector(p)
+		for _, envVar := range createEnvVarsForSecret(secret, secretName, secretConfig) {
+			p.Spec.InitContainers = appendEnvVars(p.Spec.InitContainers, selector, envVar)
+			p.Spec.Containers = appendEnvVars(p.Spec.Containers, selector, envVar)
+			p.Spec.EphemeralContainers = appendEphemeralEnvVars(p.Spec.EphemeralContainers, selector, envVar)
 		}
-		p.Spec.InitContainers = flytewebhook.AppendEnvVars(p.Spec.InitContainers, envVar)
-		p.Spec.Containers = flytewebhook.AppendEnvVars(p.Spec.Containers, envVar)
 
 		prefixEnvVar := corev1.EnvVar{
 			Name:  flytewebhook.SecretEnvVarPrefix,
-			Value: flytewebhook.K8sDefaultEnvVarPrefix,
+			Value: envVarPrefix(secretConfig),
 		}
 
-		p.Spec.InitContainers = flytewebhook.AppendEnvVars(p.Spec.InitContainers, prefixEnvVar)
-		p.Spec.Containers = flytewebhook.AppendEnvVars(p.Spec.Containers, prefixEnvVar)
+		p.Spec.InitContainers = appendEnvVars(p.Spec.InitContainers, selector, prefixEnvVar)
+		p.Spec.Containers = appendEnvVars(p.Spec.Containers, selector, prefixEnvVar)
+		p.Spec.EphemeralContainers = appendEphemeralEnvVars(p.Spec.EphemeralContainers, selector, prefixEnvVar)
 	default:
 		err := fmt.Errorf("unrecognized mount requirement [%v] for secret [%v]", secret.MountRequirement.String(), secret.Key)
 		return p, err
@@ -232,39 +480,101 @@ func injectFlyteSecretEnvVar(secret *core.Secret, p *corev1.Pod) (newP *corev1.P
 	return p, nil
 }
 
-// createK8Secret create the secret if it doesn't exist, else it does nothing
-func createK8Secret(clientSet kubernetes.Interface, k8secret *corev1.Secret) error {
-	_, err := clientSet.CoreV1().Secrets(k8secret.Namespace).Get(context.Background(), k8secret.Name, metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err := clientSet.CoreV1().Secrets(k8secret.Namespace).Create(context.Background(), k8secret, metav1.CreateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to create mlpSecret: %v", err)
-			}
-		} else {
-			return err
+// createOrUpdateK8Secret create the secret if it doesn't exist. A secret left behind by a previous pod of the same
+// name is updated with the data of the new pod, as long as it was created by the webhook.
+// The secret is created first, as it usually doesn't exist, and only read when the create finds one
+func createOrUpdateK8Secret(ctx context.Context, clientSet kubernetes.Interface, listers *Listers, k8secret *corev1.Secret) error {
+	_, err := clientSet.CoreV1().Secrets(k8secret.Namespace).Create(ctx, k8secret, metav1.CreateOptions{})
+	if err == nil {
+		log.Infof("created k8 secret: '%v' in namespace: '%v'", k8secret.Name, k8secret.Namespace)
+		return nil
+	}
+	if !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create mlpSecret: %v", err)
+	}
+
+	_, err = updateK8Secret(ctx, clientSet, listers, k8secret.Namespace, k8secret.Name, func(existing *corev1.Secret) bool {
+		if existing.Annotations == nil {
+			existing.Annotations = map[string]string{}
 		}
+		for key, value := range k8secret.Annotations {
+			existing.Annotations[key] = value
+		}
+		existing.Data = k8secret.Data
+		existing.Type = k8secret.Type
+		return true
+	})
+	if err != nil {
+		return err
 	}
-	log.Infof("created k8 secret: '%v' in namespace: '%v'", k8secret.Name, k8secret.Namespace)
+	log.Infof("updated existing k8 secret: '%v' in namespace: '%v'", k8secret.Name, k8secret.Namespace)
 	return nil
 }
 
-// deleteK8Secret deletes the secret if it exists, else it does nothing
-func deleteK8Secret(clientSet kubernetes.Interface, namespace string, secretName string) error {
-	_, err := clientSet.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			return nil
-		} else {
-			return err
-		}
+// isManagedSecret returns true when the secret was created by the webhook
+func isManagedSecret(k8secret *corev1.Secret) bool {
+	return k8secret.Labels[ManagedByLabel] == ManagedByValue
+}
+
+// newNotManagedError returns a conflict error for a secret that has the name of the webhook secret,
+// but was not created by the webhook
+func newNotManagedError(k8secret *corev1.Secret) error {
+	return errors.NewConflict(corev1.Resource("secrets"), k8secret.Name,
+		fmt.Errorf("secret already exists in namespace '%v' and is not managed by %v", k8secret.Namespace, ManagedByValue))
+}
+
+// statusCodeForError returns the http status code of the admission response for the error
+func statusCodeForError(err error) int32 {
+	if errors.IsConflict(err) {
+		return http.StatusConflict
 	}
-	err = clientSet.CoreV1().Secrets(namespace).Delete(context.Background(), secretName, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to delete mlpSecret: %v", err)
+	if fetchErr, ok := err.(*fetchError); ok {
+		return fetchErr.code
 	}
-	log.Infof("deleted k8 secret: '%v' in namespace: '%v'", secretName, namespace)
-	return nil
+	var throttledErr *client.ThrottledError
+	if goerrors.As(err, &throttledErr) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// deleteK8Secret deletes the secret if it exists and was created by the webhook, else it does nothing.
+// The delete is preconditioned on the uid and resource version of the secret that was checked, and retried when
+// the secret has changed in between. The secret is checked in the cache first, and in the api server on retry
+func deleteK8Secret(ctx context.Context, clientSet kubernetes.Interface, listers *Listers, namespace string, secretName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := listers.getSecret(ctx, clientSet, namespace, secretName)
+		// the cached secret is outdated on conflict
+		listers = nil
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if !isManagedSecret(existing) {
+			log.Warnf("skip deleting k8 secret: '%v' in namespace: '%v' not managed by %v", secretName, namespace, ManagedByValue)
+			return nil
+		}
+
+		err = clientSet.CoreV1().Secrets(namespace).Delete(ctx, secretName, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{
+				UID:             &existing.UID,
+				ResourceVersion: &existing.ResourceVersion,
+			},
+		})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			if errors.IsConflict(err) {
+				return err
+			}
+			return fmt.Errorf("failed to delete mlpSecret: %v", err)
+		}
+		log.Infof("deleted k8 secret: '%v' in namespace: '%v'", secretName, namespace)
+		return nil
+	})
 }
 
 func generateMutatingWebhookConfig(webhookConfig config.WebhookConfig, caCertFilePath string) (*admissionregistrationv1.MutatingWebhookConfiguration, error) {
@@ -272,8 +582,9 @@ func generateMutatingWebhookConfig(webhookConfig config.WebhookConfig, caCertFil
 	if err != nil {
 		return nil, err
 	}
-	fail := admissionregistrationv1.Fail
 	sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+	// containers added by webhooks called after this one are injected on reinvocation
+	reinvocationPolicy := admissionregistrationv1.IfNeededReinvocationPolicy
 
 	mutateConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
@@ -286,16 +597,8 @@ func generateMutatingWebhookConfig(webhookConfig config.WebhookConfig, caCertFil
 		Webhooks: []admissionregistrationv1.MutatingWebhook{
 			{
 				// needs to be a valid dns
-				Name: webhookConfig.WebhookName,
-				ClientConfig: admissionregistrationv1.WebhookClientConfig{
-					CABundle: caBytes, // CA bundle created earlier
-					Service: &admissionregistrationv1.ServiceReference{
-						Name:      webhookConfig.ServiceName,
-						Namespace: webhookConfig.ServiceNamespace,
-						Path:      &webhookConfig.MutatePath,
-						Port:      &webhookConfig.ServicePort,
-					},
-				},
+				Name:         webhookConfig.WebhookName,
+				ClientConfig: webhookClientConfig(webhookConfig, webhookConfig.MutatePath, caBytes),
 				Rules: []admissionregistrationv1.RuleWithOperations{
 					{
 						Operations: []admissionregistrationv1.OperationType{
@@ -308,11 +611,25 @@ func generateMutatingWebhookConfig(webhookConfig config.WebhookConfig, caCertFil
 							Resources:   []string{"pods"},
 						},
 					},
+					{
+						// debug containers attached to the pod
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods/" + EphemeralContainersSubResource},
+						},
+					},
 				},
-				FailurePolicy: &fail,
-				SideEffects:   &sideEffects,
+				FailurePolicy:      failurePolicy(webhookConfig),
+				SideEffects:        &sideEffects,
+				ReinvocationPolicy: &reinvocationPolicy,
+				TimeoutSeconds:     timeoutSeconds(webhookConfig),
 				AdmissionReviewVersions: []string{
 					"v1",
+					"v1beta1",
 				},
 				ObjectSelector: &metav1.LabelSelector{
 					MatchLabels: map[string]string{
@@ -325,6 +642,44 @@ func generateMutatingWebhookConfig(webhookConfig config.WebhookConfig, caCertFil
 	return mutateConfig, nil
 }
 
+// webhookClientConfig returns how the api server calls the webhook at path, through the service,
+// or at the URL when it is set for a webhook running outside of the cluster
+func webhookClientConfig(webhookConfig config.WebhookConfig, path string, caBytes []byte) admissionregistrationv1.WebhookClientConfig {
+	if webhookConfig.URL != "" {
+		url := strings.TrimSuffix(webhookConfig.URL, "/") + path
+		return admissionregistrationv1.WebhookClientConfig{
+			CABundle: caBytes,
+			URL:      &url,
+		}
+	}
+	return admissionregistrationv1.WebhookClientConfig{
+		CABundle: caBytes, // CA bundle created earlier
+		Service: &admissionregistrationv1.ServiceReference{
+			Name:      webhookConfig.ServiceName,
+			Namespace: webhookConfig.ServiceNamespace,
+			Path:      &path,
+			Port:      &webhookConfig.ServicePort,
+		},
+	}
+}
+
+// timeoutSeconds returns the webhook timeout, nil for the api server default when it is not set
+func timeoutSeconds(webhookConfig config.WebhookConfig) *int32 {
+	if webhookConfig.TimeoutSeconds <= 0 {
+		return nil
+	}
+	return &webhookConfig.TimeoutSeconds
+}
+
+// failurePolicy returns the failure policy of the webhooks, Fail when it is not set
+func failurePolicy(webhookConfig config.WebhookConfig) *admissionregistrationv1.FailurePolicyType {
+	policy := admissionregistrationv1.Fail
+	if webhookConfig.FailurePolicy != "" {
+		policy = admissionregistrationv1.FailurePolicyType(webhookConfig.FailurePolicy)
+	}
+	return &policy
+}
+
 // CreateOrUpdateMutatingWebhookConfig will create/update the MutatingWebhookConfiguration.
 // It will read the CA file, so if there are any update to the bundle, the CA will be updated
 func CreateOrUpdateMutatingWebhookConfig(k8sClient kubernetes.Interface, webhookConfig config.WebhookConfig, caCertFilePath string) error {
@@ -362,3 +717,14 @@ func CreateOrUpdateMutatingWebhookConfig(k8sClient kubernetes.Interface, webhook
 	log.Infof("MutatingWebhookConfiguration configured")
 	return nil
 }
+
+// DeleteMutatingWebhookConfig deletes the MutatingWebhookConfiguration, e.g. of a webhook running outside of the
+// cluster that stops. It is not an error when the config does not exist
+func DeleteMutatingWebhookConfig(ctx context.Context, k8sClient kubernetes.Interface, webhookConfig config.WebhookConfig) error {
+	err := k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(ctx, webhookConfig.Name, metav1.DeleteOptions{})
+	if err != nil && !k8errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete MutatingWebhookConfiguration: %v", err)
+	}
+	log.Infof("MutatingWebhookConfiguration deleted")
+	return nil
+}
@@ -1,25 +1,40 @@
 package webhook
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
 	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	v1 "k8s.io/api/admission/v1"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/pointer"
 	"sigs.k8s.io/yaml"
 
+	"github.com/caraml-dev/dap-secret-webhook/audit"
+	"github.com/caraml-dev/dap-secret-webhook/client"
+	mlpfake "github.com/caraml-dev/dap-secret-webhook/client/fake"
 	"github.com/caraml-dev/dap-secret-webhook/config"
 	"github.com/caraml-dev/dap-secret-webhook/test/mocks"
+	mlp "github.com/caraml-dev/mlp/api/client"
 )
 
 var admissionScheme = runtime.NewScheme()
@@ -36,30 +51,35 @@ const (
 
 func TestMutate(t *testing.T) {
 	mlpClient := &mocks.MLPClient{}
-	mlpClient.On("GetMLPSecretValue", secretGroup, secretKey).Return("secret_data", nil)
-	dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), mlpClient, codecs.UniversalDeserializer())
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("secret_data", nil)
+	auditSink := audit.NewMemorySink()
+	k8sClientSet := fake.NewSimpleClientset()
+	dapWebhook := NewDAPWebhook(k8sClientSet, nil, mlpClient, codecs.UniversalDeserializer(), auditSink, config.SecretConfig{}, 0)
 	jsonPatchType := v1.PatchTypeJSONPatch
 
-	yamlData, err := os.ReadFile("../test/mutate/pod_with_secret.yaml")
-	assert.NoError(t, err)
-	podWithSecret, err := yaml.YAMLToJSON(yamlData)
-	assert.NoError(t, err)
+	podWithSecret := loadPodWithSecret(t)
 
 	type args struct {
 		req            *v1.AdmissionReview
 		additionalFunc func()
 	}
 	var tests = []struct {
-		name string
-		args args
-		resp *v1.AdmissionResponse
+		name         string
+		args         args
+		resp         *v1.AdmissionResponse
+		auditRecords []audit.Record
 	}{
 		{
 			name: "ok create",
 			args: args{
 				req: &v1.AdmissionReview{
 					Request: &v1.AdmissionRequest{
+						UID:       "create-uid",
 						Operation: "CREATE",
+						UserInfo: authenticationv1.UserInfo{
+							Username: "system:serviceaccount:flyte:flytepropeller",
+							Groups:   []string{"system:serviceaccounts"},
+						},
 						// flyte.secrets/s0 is encoded using Group: 'TestGroup', Key: 'TestSecretKey'
 						Object: runtime.RawExtension{
 							Raw: podWithSecret,
@@ -67,24 +87,45 @@ func TestMutate(t *testing.T) {
 					},
 				},
 				additionalFunc: func() {
-					mlpClient.AssertCalled(t, "GetMLPSecretValue", secretGroup, secretKey)
+					mlpClient.AssertCalled(t, "GetMLPSecretValue", mock.Anything, secretGroup, secretKey)
+					secret, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod-with-secret", metav1.GetOptions{})
+					assert.NoError(t, err)
+					assert.Equal(t, ManagedByValue, secret.Labels[ManagedByLabel])
+					assert.Equal(t, "pod-with-secret", secret.Annotations[PodAnnotation])
+					assert.Equal(t, "create-uid", secret.Annotations[AdmissionUIDAnnotation])
+					assert.NotEmpty(t, secret.Annotations[CreatedAtAnnotation])
 				},
 			},
 			// Expect an 'add' patch with env var _FSEC_{Group}_{Key} with value from secret named {pod_name}, with key {Key}
-			// and another prefix env by flyte '_FSEC_'
+			// and another prefix env by flyte '_FSEC_', and the containers of the pod recorded as primary containers
 			resp: &v1.AdmissionResponse{
 				Allowed: true,
-				Patch: []byte(`[{"op":"add","path":"/spec/containers/0/env",` +
+				Patch: []byte(`[{"op":"add","path":"/metadata/annotations/dap-secret-webhook~1primary-containers","value":"pod-with-secret"},` +
+					`{"op":"add","path":"/spec/containers/0/env",` +
 					`"value":[{"name":"_FSEC_TESTGROUP_TESTSECRETKEY","valueFrom":{"secretKeyRef":{"key":"testsecretkey",` +
 					`"name":"pod-with-secret","optional":true}}},{"name":"FLYTE_SECRETS_ENV_PREFIX","value":"_FSEC_"}]}]`),
 				PatchType: &jsonPatchType,
 			},
+			auditRecords: []audit.Record{
+				{
+					AdmissionUID: "create-uid",
+					Operation:    "CREATE",
+					Username:     "system:serviceaccount:flyte:flytepropeller",
+					UserGroups:   []string{"system:serviceaccounts"},
+					Namespace:    "testgroup",
+					Pod:          "pod-with-secret",
+					Project:      "testgroup",
+					Keys:         []string{secretKey},
+					Decision:     audit.DecisionAllowed,
+				},
+			},
 		},
 		{
 			name: "ok delete",
 			args: args{
 				req: &v1.AdmissionReview{
 					Request: &v1.AdmissionRequest{
+						UID:       "delete-uid",
 						Operation: "DELETE",
 						OldObject: runtime.RawExtension{
 							Raw: podWithSecret,
@@ -95,6 +136,16 @@ func TestMutate(t *testing.T) {
 			resp: &v1.AdmissionResponse{
 				Allowed: true,
 			},
+			auditRecords: []audit.Record{
+				{
+					AdmissionUID: "delete-uid",
+					Operation:    "DELETE",
+					Namespace:    "testgroup",
+					Pod:          "pod-with-secret",
+					Project:      "testgroup",
+					Decision:     audit.DecisionAllowed,
+				},
+			},
 		},
 		{
 			name: "invalid operation",
@@ -133,29 +184,262 @@ func TestMutate(t *testing.T) {
 					Message: `webhook require secretkey to be set. Secret: [group:"TestGroup" mount_requirement:ENV_VAR ]`,
 				},
 			},
+			auditRecords: []audit.Record{
+				{
+					Operation: "CREATE",
+					Keys:      []string{""},
+					Decision:  audit.DecisionDenied,
+					Reason:    `webhook require secretkey to be set. Secret: [group:"TestGroup" mount_requirement:ENV_VAR ]`,
+				},
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			admissionResponse := dapWebhook.Mutate(*tt.args.req)
+			auditSink.Reset()
+			admissionResponse := dapWebhook.Mutate(context.Background(), *tt.args.req)
 			if tt.args.additionalFunc != nil {
 				tt.args.additionalFunc()
 			}
-			//fmt.Println(string(admissionResponse.Patch))
+			// the order of the patch operations follows the iteration of the pod fields, which is random
+			if tt.resp.Patch != nil {
+				var expected, actual []map[string]interface{}
+				assert.NoError(t, json.Unmarshal(tt.resp.Patch, &expected))
+				assert.NoError(t, json.Unmarshal(admissionResponse.Patch, &actual))
+				assert.ElementsMatch(t, expected, actual)
+				admissionResponse.Patch = tt.resp.Patch
+			}
 			assert.Equal(t, tt.resp, admissionResponse)
+
+			records := auditSink.Records()
+			for i := range records {
+				assert.False(t, records[i].Timestamp.IsZero())
+				records[i].Timestamp = time.Time{}
+			}
+			assert.Equal(t, tt.auditRecords, records)
+		})
+	}
+}
+
+func TestMutateIdempotent(t *testing.T) {
+	mlpClient := &mocks.MLPClient{}
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("secret_data", nil)
+	dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), nil, mlpClient, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+
+	podWithSecret := loadPodWithSecret(t)
+	mutate := func(operation v1.Operation, subResource string, raw []byte) []byte {
+		return mutateAndPatch(t, dapWebhook, operation, subResource, raw)
+	}
+
+	created := mutate(v1.Create, "", podWithSecret)
+	// reinvocation of the webhook does not change the pod
+	assert.JSONEq(t, string(created), string(mutate(v1.Create, "", created)))
+
+	// debug container attached to the pod gets the same env var
+	pod := &corev1.Pod{}
+	assert.NoError(t, json.Unmarshal(created, pod))
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"},
+	}}
+	withDebugger, err := json.Marshal(pod)
+	assert.NoError(t, err)
+	updated := mutate(v1.Update, EphemeralContainersSubResource, withDebugger)
+
+	assert.NoError(t, json.Unmarshal(updated, pod))
+	assert.Equal(t, pod.Spec.Containers[0].Env, pod.Spec.EphemeralContainers[0].Env)
+	assert.JSONEq(t, string(updated), string(mutate(v1.Update, EphemeralContainersSubResource, updated)))
+	mlpClient.AssertNumberOfCalls(t, "GetMLPSecretValue", 2)
+}
+
+func TestMutateEphemeralTemplates(t *testing.T) {
+	mlpClient := &mocks.MLPClient{}
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("secret_data", nil)
+	dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), nil, mlpClient, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+
+	pod := &corev1.Pod{}
+	assert.NoError(t, json.Unmarshal(loadPodWithSecret(t), pod))
+	pod.Annotations[TemplateAnnotationPrefix+"netrc"] = "machine api.local password {{ .testsecretkey }}"
+	raw, err := json.Marshal(pod)
+	assert.NoError(t, err)
+	created := mutateAndPatch(t, dapWebhook, v1.Create, "", raw)
+
+	// debug container attached to the pod gets the same config files
+	assert.NoError(t, json.Unmarshal(created, pod))
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"},
+	}}
+	withDebugger, err := json.Marshal(pod)
+	assert.NoError(t, err)
+	updated := mutateAndPatch(t, dapWebhook, v1.Update, EphemeralContainersSubResource, withDebugger)
+
+	assert.NoError(t, json.Unmarshal(updated, pod))
+	assert.Equal(t, pod.Spec.Containers[0].VolumeMounts, pod.Spec.EphemeralContainers[0].VolumeMounts)
+	assert.Equal(t, []corev1.VolumeMount{
+		{Name: templateVolumeName, ReadOnly: true, MountPath: defaultTemplateDir},
+	}, pod.Spec.EphemeralContainers[0].VolumeMounts)
+	assert.JSONEq(t, string(updated), string(mutateAndPatch(t, dapWebhook, v1.Update, EphemeralContainersSubResource, updated)))
+}
+
+func TestMutateReinvocationSidecar(t *testing.T) {
+	mlpClient := &mocks.MLPClient{}
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("secret_data", nil)
+	dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), nil, mlpClient, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+
+	created := mutateAndPatch(t, dapWebhook, v1.Create, "", loadPodWithSecret(t))
+	pod := &corev1.Pod{}
+	assert.NoError(t, json.Unmarshal(created, pod))
+	assert.Equal(t, "pod-with-secret", pod.Annotations[PrimaryContainersAnnotation])
+
+	// a webhook called after this one adds a sidecar, which is not injected on reinvocation
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "istio-proxy", Image: "istio/proxyv2"})
+	withSidecar, err := json.Marshal(pod)
+	assert.NoError(t, err)
+	reinvoked := mutateAndPatch(t, dapWebhook, v1.Create, "", withSidecar)
+	assert.JSONEq(t, string(withSidecar), string(reinvoked))
+}
+
+func TestMutateDeadline(t *testing.T) {
+	podWithSecret := loadPodWithSecret(t)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		timeout time.Duration
+		err     string
+	}{
+		{
+			name:    "webhook timeout",
+			ctx:     context.Background(),
+			timeout: 100 * time.Millisecond,
+			err:     "context deadline exceeded",
+		},
+		{
+			name: "api server gave up",
+			ctx:  cancelled,
+			err:  "context canceled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mlpClient := &mocks.MLPClient{}
+			// MLP does not respond until the admission is abandoned
+			mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return(
+				func(ctx context.Context, project string, name string) (string, error) {
+					<-ctx.Done()
+					return "", ctx.Err()
+				})
+			dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), nil, mlpClient, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, tt.timeout)
+
+			resp := dapWebhook.Mutate(tt.ctx, v1.AdmissionReview{Request: &v1.AdmissionRequest{
+				Operation: v1.Create,
+				Object:    runtime.RawExtension{Raw: podWithSecret},
+			}})
+			assert.False(t, resp.Allowed)
+			assert.Equal(t, tt.err, resp.Result.Message)
 		})
 	}
 }
 
+func TestMutateStaleSecret(t *testing.T) {
+	podWithSecret := loadPodWithSecret(t)
+
+	mlpClient := &mocks.MLPClient{}
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("testsecretdata", nil).Once()
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("", fmt.Errorf("mlp unavailable")).Once()
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("newsecretdata", nil).Once()
+	k8sClientSet := fake.NewSimpleClientset()
+	dapWebhook := NewDAPWebhook(k8sClientSet, nil, client.NewStaleCacheClient(mlpClient, time.Minute),
+		codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+	staleAnnotation := func(podJSON []byte) string {
+		pod := &corev1.Pod{}
+		assert.NoError(t, json.Unmarshal(podJSON, pod))
+		return pod.Annotations[StaleSecretsAnnotation]
+	}
+
+	created := mutateAndPatch(t, dapWebhook, v1.Create, "", podWithSecret)
+	assert.Equal(t, "", staleAnnotation(created))
+
+	// the last known good value is served while MLP is unavailable, and the pod is marked
+	stale := mutateAndPatch(t, dapWebhook, v1.Create, "", podWithSecret)
+	assert.Equal(t, secretKey, staleAnnotation(stale))
+	secret, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod-with-secret", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "testsecretdata", string(secret.Data[secretKey]))
+
+	// the mark is removed when MLP answers fresh on reinvocation
+	reinvoked := mutateAndPatch(t, dapWebhook, v1.Create, "", stale)
+	assert.Equal(t, "", staleAnnotation(reinvoked))
+	secret, err = k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod-with-secret", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "newsecretdata", string(secret.Data[secretKey]))
+	mlpClient.AssertExpectations(t)
+}
+
+func TestMutateThrottled(t *testing.T) {
+	podWithSecret := loadPodWithSecret(t)
+
+	server := mlpfake.NewMLPServer(mlpfake.Projects{secretGroup: {secretKey: "testsecretdata"}})
+	defer server.Close()
+	mlpConfig := mlp.NewConfiguration()
+	mlpConfig.BasePath = server.URL
+	// the project and secrets calls of the first pod take the whole burst
+	mlpClient := client.NewAPIClient(mlp.NewAPIClient(mlpConfig), config.MLPConfig{
+		RetryMaxAttempts: 1,
+		RetryBudget:      time.Second,
+		RateLimit:        0.1,
+		RateBurst:        2,
+	})
+	dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), nil, mlpClient, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+	mutate := func() *v1.AdmissionResponse {
+		return dapWebhook.Mutate(context.Background(), v1.AdmissionReview{Request: &v1.AdmissionRequest{
+			Operation: v1.Create,
+			Object:    runtime.RawExtension{Raw: podWithSecret},
+		}})
+	}
+
+	assert.True(t, mutate().Allowed)
+	resp := mutate()
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, int32(http.StatusTooManyRequests), resp.Result.Code)
+	assert.Equal(t, "cannot get project from mlp, too many requests to mlp for project 'testgroup', "+
+		"rate limit of 0.1 per second exceeded, retry later", resp.Result.Message)
+}
+
+func TestAdmissionContext(t *testing.T) {
+	tests := []struct {
+		timeout  time.Duration
+		deadline time.Duration
+	}{
+		{timeout: 10 * time.Second, deadline: 9 * time.Second},
+		{timeout: time.Second, deadline: 500 * time.Millisecond},
+		{timeout: 0},
+	}
+	for _, tt := range tests {
+		dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), nil, nil, nil, nil, config.SecretConfig{}, tt.timeout)
+		start := time.Now()
+		ctx, cancel := dapWebhook.admissionContext(context.Background())
+		deadline, ok := ctx.Deadline()
+		cancel()
+		assert.Equal(t, tt.timeout > 0, ok)
+		if ok {
+			assert.WithinDuration(t, start.Add(tt.deadline), deadline, 100*time.Millisecond)
+		}
+	}
+}
+
 func TestMutatingWebhookConfig(t *testing.T) {
 
 	// namespace is skipped due to limitation in fake.NewSimpleClientset
 	config := config.WebhookConfig{
-		Name:        "wh_name",
-		ServiceName: "service-name",
-		WebhookName: "local.cluster.svc",
-		ServicePort: 8080,
-		MutatePath:  "/test",
+		Name:           "wh_name",
+		ServiceName:    "service-name",
+		WebhookName:    "local.cluster.svc",
+		ServicePort:    8080,
+		MutatePath:     "/test",
+		TimeoutSeconds: 10,
 	}
 	certPath := "../test/mutate/dummy_ca.cert"
 	// any file
@@ -175,6 +459,188 @@ func TestMutatingWebhookConfig(t *testing.T) {
 
 	err = CreateOrUpdateMutatingWebhookConfig(k8Client, config, certPath)
 	assert.NoError(t, err)
+
+	// the config of a webhook running outside of the cluster is deleted on exit
+	config.FailurePolicy = "Ignore"
+	output, err = generateMutatingWebhookConfig(config, certPath)
+	assert.NoError(t, err)
+	assert.Equal(t, admissionregistrationv1.Ignore, *output.Webhooks[0].FailurePolicy)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, DeleteMutatingWebhookConfig(context.Background(), k8Client, config))
+	}
+	_, err = k8Client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), config.Name, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestWebhookClientConfig(t *testing.T) {
+	webhookConfig := config.WebhookConfig{
+		ServiceName:      "dap-secret-webhook",
+		ServiceNamespace: "flyte",
+		ServicePort:      443,
+	}
+	assert.Equal(t, admissionregistrationv1.WebhookClientConfig{
+		CABundle: []byte("ca"),
+		Service: &admissionregistrationv1.ServiceReference{
+			Name:      "dap-secret-webhook",
+			Namespace: "flyte",
+			Path:      pointer.String("/mutate"),
+			Port:      pointer.Int32(443),
+		},
+	}, webhookClientConfig(webhookConfig, "/mutate", []byte("ca")))
+
+	webhookConfig.URL = "https://host.docker.internal:8443/"
+	assert.Equal(t, admissionregistrationv1.WebhookClientConfig{
+		CABundle: []byte("ca"),
+		URL:      pointer.String("https://host.docker.internal:8443/mutate"),
+	}, webhookClientConfig(webhookConfig, "/mutate", []byte("ca")))
+}
+
+func TestCreateOrUpdateK8Secret(t *testing.T) {
+	newSecret := func(labels map[string]string, data string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: secretGroup, Labels: labels},
+			Data:       map[string][]byte{data: []byte(data)},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	}
+	managedLabels := map[string]string{ManagedByLabel: ManagedByValue}
+
+	tests := []struct {
+		name     string
+		existing *corev1.Secret
+		expected *corev1.Secret
+		err      string
+	}{
+		{
+			name:     "create new secret",
+			expected: newSecret(managedLabels, "new"),
+		},
+		{
+			name:     "update secret left by previous pod of the same name",
+			existing: newSecret(map[string]string{ManagedByLabel: ManagedByValue, "other": "label"}, "stale"),
+			expected: newSecret(map[string]string{ManagedByLabel: ManagedByValue, "other": "label"}, "new"),
+		},
+		{
+			name:     "refuse secret not managed by the webhook",
+			existing: newSecret(map[string]string{"app": "user"}, "user"),
+			expected: newSecret(map[string]string{"app": "user"}, "user"),
+			err: `Operation cannot be fulfilled on secrets "pod": ` +
+				`secret already exists in namespace 'testgroup' and is not managed by dap-secret-webhook`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sClientSet := fake.NewSimpleClientset()
+			if tt.existing != nil {
+				k8sClientSet = fake.NewSimpleClientset(tt.existing)
+			}
+
+			err := createOrUpdateK8Secret(context.Background(), k8sClientSet, nil, newSecret(managedLabels, "new"))
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.Equal(t, int32(http.StatusConflict), statusCodeForError(err))
+			} else {
+				assert.NoError(t, err)
+			}
+
+			got, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod", metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected.Labels, got.Labels)
+			assert.Equal(t, tt.expected.Data, got.Data)
+		})
+	}
+}
+
+func TestDeleteK8Secret(t *testing.T) {
+	newSecret := func(labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: secretGroup, Labels: labels, UID: "secret-uid"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		existing  *corev1.Secret
+		conflicts int
+		deleted   bool
+	}{
+		{
+			name: "secret not found",
+		},
+		{
+			name:     "delete secret managed by the webhook",
+			existing: newSecret(map[string]string{ManagedByLabel: ManagedByValue}),
+			deleted:  true,
+		},
+		{
+			name:      "retry delete when the secret changed",
+			existing:  newSecret(map[string]string{ManagedByLabel: ManagedByValue}),
+			conflicts: 1,
+			deleted:   true,
+		},
+		{
+			name:     "keep secret not managed by the webhook",
+			existing: newSecret(map[string]string{"app": "user"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sClientSet := fake.NewSimpleClientset()
+			if tt.existing != nil {
+				k8sClientSet = fake.NewSimpleClientset(tt.existing)
+			}
+			var preconditions []*metav1.Preconditions
+			conflicts := tt.conflicts
+			k8sClientSet.PrependReactor("delete", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				preconditions = append(preconditions, action.(k8stesting.DeleteActionImpl).DeleteOptions.Preconditions)
+				if conflicts > 0 {
+					conflicts--
+					return true, nil, errors.NewConflict(corev1.Resource("secrets"), "pod", fmt.Errorf("changed"))
+				}
+				return false, nil, nil
+			})
+
+			assert.NoError(t, deleteK8Secret(context.Background(), k8sClientSet, nil, secretGroup, "pod"))
+
+			_, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod", metav1.GetOptions{})
+			assert.Equal(t, tt.existing != nil && !tt.deleted, err == nil)
+			if tt.deleted {
+				assert.Equal(t, tt.conflicts+1, len(preconditions))
+				for _, p := range preconditions {
+					assert.Equal(t, tt.existing.UID, *p.UID)
+				}
+			} else {
+				assert.Empty(t, preconditions)
+			}
+		})
+	}
+}
+
+// mutateAndPatch calls the webhook with the raw pod and returns the pod patched with the response
+func mutateAndPatch(t *testing.T, dapWebhook DAPWebhook, operation v1.Operation, subResource string, raw []byte) []byte {
+	resp := dapWebhook.Mutate(context.Background(), v1.AdmissionReview{Request: &v1.AdmissionRequest{
+		Operation:   operation,
+		SubResource: subResource,
+		Object:      runtime.RawExtension{Raw: raw},
+	}})
+	assert.True(t, resp.Allowed)
+	if len(resp.Patch) == 0 {
+		return raw
+	}
+	patch, err := jsonpatch.DecodePatch(resp.Patch)
+	assert.NoError(t, err)
+	patched, err := patch.Apply(raw)
+	assert.NoError(t, err)
+	return patched
+}
+
+// loadPodWithSecret returns /test/mutate/pod_with_secret.yaml as json
+func loadPodWithSecret(t *testing.T) []byte {
+	yamlData, err := os.ReadFile("../test/mutate/pod_with_secret.yaml")
+	assert.NoError(t, err)
+	podWithSecret, err := yaml.YAMLToJSON(yamlData)
+	assert.NoError(t, err)
+	return podWithSecret
 }
 
 // this is used to check the secret used in /test/mutate/pod_with_secret.yaml is using an encrypted secret expected by other test
@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+
+	corev1 "k8s.io/api/core/v1"
+	k8errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/mlp/api/log"
+)
+
+const (
+	// ExecutionIDLabel and NodeIDLabel are the labels set by Flyte Propeller on the pods of an execution node
+	ExecutionIDLabel = "execution-id"
+	NodeIDLabel      = "node-id"
+
+	sharedSecretNamePrefix = "flyte-secrets"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// secretNameForPod returns the name of the k8 secret the pod reads from. It is the pod name, unless the
+// secret is shared by all the pods of the Flyte execution node. The secret is only shared by the pods with owners,
+// as it is not deleted with the pods but garbage collected with their owners
+func secretNameForPod(pod *corev1.Pod, secrets []*core.Secret, secretConfig config.SecretConfig) string {
+	if name, ok := sharedSecretName(pod, secrets); ok && secretConfig.SharedPerExecution && len(pod.OwnerReferences) > 0 {
+		return name
+	}
+	return pod.Name
+}
+
+// sharedSecretName returns the name of the secret shared by the pods of the same Flyte execution node.
+// The requested keys are hashed in the name, so that pods requesting different keys do not share a secret.
+// It returns false when the pod is not labelled with the execution and node id
+func sharedSecretName(pod *corev1.Pod, secrets []*core.Secret) (string, bool) {
+	executionID := pod.Labels[ExecutionIDLabel]
+	nodeID := pod.Labels[NodeIDLabel]
+	if executionID == "" || nodeID == "" {
+		return "", false
+	}
+
+	keys := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, secret.Key)
+	}
+	sort.Strings(keys)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.Join(keys, ",")))
+
+	name := fmt.Sprintf("%v-%v-%v-%08x", sharedSecretNamePrefix, executionID, nodeID, h.Sum32())
+	return strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-"), true
+}
+
+// sharedOwnerReferences returns the owners of the pod, usually the FlyteWorkflow of the execution, for the shared
+// secret to be garbage collected with the execution. The secret does not block the deletion of the owner.
+// The secret is not deleted with the last pod, as a pod being admitted is not listed yet and would lose it
+func sharedOwnerReferences(pod *corev1.Pod) []metav1.OwnerReference {
+	refs := make([]metav1.OwnerReference, 0, len(pod.OwnerReferences))
+	for _, ref := range pod.OwnerReferences {
+		refs = append(refs, metav1.OwnerReference{
+			APIVersion: ref.APIVersion,
+			Kind:       ref.Kind,
+			Name:       ref.Name,
+			UID:        ref.UID,
+		})
+	}
+	return refs
+}
+
+// getReusableSharedSecret returns the shared secret when it already holds every requested key, so that MLP
+// is only called by the first pod of the execution node. It returns nil when the secret has to be created
+func getReusableSharedSecret(
+	ctx context.Context,
+	clientSet kubernetes.Interface,
+	listers *Listers,
+	namespace string,
+	name string,
+	secrets []*core.Secret,
+) (*corev1.Secret, error) {
+	k8secret, err := listers.getSecret(ctx, clientSet, namespace, name)
+	if err != nil {
+		if k8errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !isManagedSecret(k8secret) {
+		return nil, newNotManagedError(k8secret)
+	}
+	if !hasSecretData(k8secret.Data, secrets) {
+		return nil, nil
+	}
+	return k8secret, nil
+}
+
+// hasSecretData returns true when the data has the keys of all the secrets
+func hasSecretData(data map[string][]byte, secrets []*core.Secret) bool {
+	for _, secret := range secrets {
+		if _, ok := data[secret.Key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// createOrReferenceSharedK8Secret creates the shared secret if it doesn't exist, else it adds the missing
+// owner references and data keys to the existing secret
+func createOrReferenceSharedK8Secret(ctx context.Context, clientSet kubernetes.Interface, listers *Listers, k8secret *corev1.Secret) error {
+	_, err := clientSet.CoreV1().Secrets(k8secret.Namespace).Create(ctx, k8secret, metav1.CreateOptions{})
+	if err == nil {
+		log.Infof("created shared k8 secret: '%v' in namespace: '%v'", k8secret.Name, k8secret.Namespace)
+		return nil
+	}
+	// another pod of the execution node may have created the secret concurrently, or before
+	if !k8errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create mlpSecret: %v", err)
+	}
+
+	updated, err := updateK8Secret(ctx, clientSet, listers, k8secret.Namespace, k8secret.Name, func(existing *corev1.Secret) bool {
+		updated := false
+		for _, ref := range k8secret.OwnerReferences {
+			if !hasOwnerReference(existing.OwnerReferences, ref) {
+				existing.OwnerReferences = append(existing.OwnerReferences, ref)
+				updated = true
+			}
+		}
+		if existing.Data == nil {
+			existing.Data = map[string][]byte{}
+		}
+		for key, value := range k8secret.Data {
+			if _, ok := existing.Data[key]; !ok {
+				existing.Data[key] = value
+				updated = true
+			}
+		}
+		return updated
+	})
+	if err != nil {
+		return err
+	}
+	if updated {
+		log.Infof("referenced shared k8 secret: '%v' in namespace: '%v'", k8secret.Name, k8secret.Namespace)
+	}
+	return nil
+}
+
+func hasOwnerReference(refs []metav1.OwnerReference, ref metav1.OwnerReference) bool {
+	for _, r := range refs {
+		if r.UID == ref.UID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/dap-secret-webhook/test/mocks"
+)
+
+func TestSharedSecretName(t *testing.T) {
+	newPod := func(labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Labels: labels}}
+	}
+	keys := []*core.Secret{{Key: "b"}, {Key: "a"}}
+	reversedKeys := []*core.Secret{{Key: "a"}, {Key: "b"}}
+
+	name, ok := sharedSecretName(newPod(map[string]string{ExecutionIDLabel: "F_abc", NodeIDLabel: "n0"}), keys)
+	assert.True(t, ok)
+	assert.Equal(t, "flyte-secrets-f-abc-n0-08876b46", name)
+
+	// the order of the keys does not matter
+	reversedName, _ := sharedSecretName(newPod(map[string]string{ExecutionIDLabel: "F_abc", NodeIDLabel: "n0"}), reversedKeys)
+	assert.Equal(t, name, reversedName)
+
+	otherName, _ := sharedSecretName(newPod(map[string]string{ExecutionIDLabel: "F_abc", NodeIDLabel: "n0"}), keys[:1])
+	assert.NotEqual(t, name, otherName)
+
+	_, ok = sharedSecretName(newPod(map[string]string{ExecutionIDLabel: "abc"}), keys)
+	assert.False(t, ok)
+}
+
+func TestSharedSecretPerExecution(t *testing.T) {
+	annotations, err := secrets.MarshalSecretsToMapStrings([]*core.Secret{{Group: secretGroup, Key: secretKey}})
+	assert.NoError(t, err)
+	newPod := func(name string, ownerUID types.UID) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: secretGroup,
+				Labels: map[string]string{
+					secrets.PodLabel: secrets.PodLabelValue,
+					ExecutionIDLabel: "exec",
+					NodeIDLabel:      "n0",
+				},
+				Annotations: annotations,
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "flyte.lyft.com/v1alpha1", Kind: "FlyteWorkflow", Name: "exec", UID: ownerUID},
+				},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+	}
+	pods := []*corev1.Pod{newPod("exec-n0-0", "owner-0"), newPod("exec-n0-1", "owner-1")}
+
+	k8sClientSet := fake.NewSimpleClientset(pods[0], pods[1])
+	mlpClient := &mocks.MLPClient{}
+	mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("secret_data", nil)
+	dapWebhook := NewDAPWebhook(k8sClientSet, nil, mlpClient, codecs.UniversalDeserializer(), nil,
+		config.SecretConfig{SharedPerExecution: true}, 0)
+	secretName, _ := sharedSecretName(pods[0], []*core.Secret{{Key: secretKey}})
+
+	for _, pod := range pods {
+		raw, err := json.Marshal(pod)
+		assert.NoError(t, err)
+		resp := dapWebhook.Mutate(context.Background(), v1.AdmissionReview{Request: &v1.AdmissionRequest{
+			Operation: v1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		assert.True(t, resp.Allowed)
+		assert.Contains(t, string(resp.Patch), `"name":"`+secretName+`"`)
+	}
+	// the secret value is only fetched for the first pod
+	mlpClient.AssertNumberOfCalls(t, "GetMLPSecretValue", 1)
+
+	secret, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), secretName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "secret_data", string(secret.Data[secretKey]))
+	assert.Equal(t, 2, len(secret.OwnerReferences))
+
+	deletePod := func(pod *corev1.Pod) {
+		raw, err := json.Marshal(pod)
+		assert.NoError(t, err)
+		resp := dapWebhook.Mutate(context.Background(), v1.AdmissionReview{Request: &v1.AdmissionRequest{
+			Operation: v1.Delete,
+			OldObject: runtime.RawExtension{Raw: raw},
+		}})
+		assert.True(t, resp.Allowed)
+		assert.NoError(t, k8sClientSet.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{}))
+	}
+
+	// the secret is not deleted with the pods, as a pod being admitted may reference it, but with the owners
+	deletePod(pods[0])
+	deletePod(pods[1])
+	_, err = k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), secretName, metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestSecretNameForPod(t *testing.T) {
+	keys := []*core.Secret{{Key: secretKey}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   "exec-n0-0",
+		Labels: map[string]string{ExecutionIDLabel: "exec", NodeIDLabel: "n0"},
+	}}
+	shared := config.SecretConfig{SharedPerExecution: true}
+	sharedName, _ := sharedSecretName(pod, keys)
+
+	// a secret without owners would never be deleted
+	assert.Equal(t, "exec-n0-0", secretNameForPod(pod, keys, shared))
+
+	pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "flyte.lyft.com/v1alpha1", Kind: "FlyteWorkflow", Name: "exec"}}
+	assert.Equal(t, sharedName, secretNameForPod(pod, keys, shared))
+	assert.Equal(t, "exec-n0-0", secretNameForPod(pod, keys, config.SecretConfig{}))
+}
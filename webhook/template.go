@@ -0,0 +1,283 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// TemplateAnnotationPrefix is followed by the name of a Go text/template, that is rendered with the secrets of the
+	// pod into a secret key of the same name, e.g. 'dap-secret-webhook/template.pgpass'.
+	// The secrets are referred to by the secret key, e.g. '{{ .db_password }}' or '{{ index . "db-password" }}'
+	TemplateAnnotationPrefix = "dap-secret-webhook/template."
+	// TemplatePathAnnotationPrefix is followed by the name of the template, for the path the rendered file is mounted at.
+	// The file is mounted in the default template directory when it is not set. The directory of the path is mounted
+	// over, so it should only hold the rendered templates
+	TemplatePathAnnotationPrefix = "dap-secret-webhook/template-path."
+
+	defaultTemplateDir = "/etc/dap-secret-webhook/templates"
+	templateVolumeName = "dap-secret-webhook-templates"
+)
+
+// secretTemplate is a config file rendered from the secrets and mounted into the containers
+type secretTemplate struct {
+	name string
+	path string
+	tmpl *template.Template
+}
+
+// parseTemplates returns the templates in the pod annotations, ordered by name. The template name must not clash
+// with the secrets exposed to the pod, as both are keys of the same k8 secret, and the template must only refer to
+// the exposed secrets
+func parseTemplates(pod *corev1.Pod, secrets []*core.Secret) ([]secretTemplate, error) {
+	exposed := map[string]bool{}
+	for _, secret := range secrets {
+		exposed[secret.Key] = true
+	}
+
+	var templates []secretTemplate
+	for annotation, value := range pod.Annotations {
+		name, ok := strings.CutPrefix(annotation, TemplateAnnotationPrefix)
+		if !ok {
+			continue
+		}
+		if msgs := validation.IsConfigMapKey(name); len(msgs) > 0 {
+			return nil, fmt.Errorf("template name '%v' is not a valid secret data key: %v", name, strings.Join(msgs, ", "))
+		}
+		if exposed[name] {
+			return nil, fmt.Errorf("template '%v' clashes with secret '%v'", name, name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template '%v': %v", name, err)
+		}
+		keys := map[string]bool{}
+		referredKeys(tmpl.Tree.Root, false, keys)
+		for _, key := range sortedKeys(keys) {
+			if !exposed[key] {
+				return nil, fmt.Errorf("template '%v' refers to secret '%v' that is not exposed to the pod", name, key)
+			}
+		}
+
+		mountPath := path.Join(defaultTemplateDir, name)
+		if p, ok := pod.Annotations[TemplatePathAnnotationPrefix+name]; ok {
+			if !path.IsAbs(p) {
+				return nil, fmt.Errorf("path '%v' of template '%v' is not absolute", p, name)
+			}
+			mountPath = path.Clean(p)
+			if path.Dir(mountPath) == "/" {
+				return nil, fmt.Errorf("path '%v' of template '%v' is in the root directory", p, name)
+			}
+		}
+		templates = append(templates, secretTemplate{name: name, path: mountPath, tmpl: tmpl})
+	}
+
+	for annotation := range pod.Annotations {
+		if name, ok := strings.CutPrefix(annotation, TemplatePathAnnotationPrefix); ok {
+			if _, ok := pod.Annotations[TemplateAnnotationPrefix+name]; !ok {
+				return nil, fmt.Errorf("path is set for template '%v' that does not exist", name)
+			}
+		}
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].name < templates[j].name
+	})
+	paths := map[string]string{}
+	for _, t := range templates {
+		if other, ok := paths[t.path]; ok {
+			return nil, fmt.Errorf("templates '%v' and '%v' are mounted at the same path '%v'", other, t.name, t.path)
+		}
+		paths[t.path] = t.name
+	}
+	return templates, nil
+}
+
+// referredKeys adds the secret keys the template refers to, with '.key', '$.key' or 'index . "key"', to keys.
+// The dot is rebound in the body of range and with, where only '$.key' refers to a secret
+func referredKeys(node parse.Node, rebound bool, keys map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			referredKeys(child, rebound, keys)
+		}
+	case *parse.ActionNode:
+		referredKeys(n.Pipe, rebound, keys)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			referredKeys(cmd, rebound, keys)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) >= 3 && !rebound {
+			ident, isIdent := n.Args[0].(*parse.IdentifierNode)
+			_, isDot := n.Args[1].(*parse.DotNode)
+			key, isString := n.Args[2].(*parse.StringNode)
+			if isIdent && ident.Ident == "index" && isDot && isString {
+				keys[key.Text] = true
+			}
+		}
+		for _, arg := range n.Args {
+			referredKeys(arg, rebound, keys)
+		}
+	case *parse.FieldNode:
+		if !rebound {
+			keys[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			keys[n.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		referredKeys(n.Pipe, rebound, keys)
+		referredKeys(n.List, rebound, keys)
+		referredKeys(n.ElseList, rebound, keys)
+	case *parse.RangeNode:
+		referredKeys(n.Pipe, rebound, keys)
+		referredKeys(n.List, true, keys)
+		referredKeys(n.ElseList, rebound, keys)
+	case *parse.WithNode:
+		referredKeys(n.Pipe, rebound, keys)
+		referredKeys(n.List, true, keys)
+		referredKeys(n.ElseList, rebound, keys)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renderTemplates returns the k8 secret data of the templates rendered with the secret data.
+// It fails when a template refers to a secret that is not in the data
+func renderTemplates(templates []secretTemplate, data map[string][]byte) (map[string][]byte, error) {
+	values := make(map[string]string, len(data))
+	for key, value := range data {
+		values[key] = string(value)
+	}
+
+	rendered := map[string][]byte{}
+	for _, t := range templates {
+		buf := &bytes.Buffer{}
+		if err := t.tmpl.Execute(buf, values); err != nil {
+			return nil, fmt.Errorf("failed to render template '%v': %v", t.name, err)
+		}
+		rendered[t.name] = buf.Bytes()
+	}
+	return rendered, nil
+}
+
+// mountTemplates mounts the rendered templates as read only files to the selected containers. The templates are
+// mounted with one secret volume per directory, instead of one file with subPath, so that the kubelet updates the
+// files when the secret is rotated. The files are readable by any user, as the containers may not run as root
+func mountTemplates(p *corev1.Pod, templates []secretTemplate, secretName string) *corev1.Pod {
+	volumes, mounts := templateVolumes(templates, secretName)
+	selector := newContainerSelector(p)
+	for i, volume := range volumes {
+		if !hasVolume(p.Spec.Volumes, volume.Name) {
+			p.Spec.Volumes = append(p.Spec.Volumes, volume)
+		}
+		p.Spec.InitContainers = appendVolumeMount(p.Spec.InitContainers, selector, mounts[i])
+		p.Spec.Containers = appendVolumeMount(p.Spec.Containers, selector, mounts[i])
+	}
+	return p
+}
+
+// mountEphemeralTemplates mounts the rendered templates to the selected debug containers attached to the pod. The
+// volumes of the pod cannot be changed once it is created, hence only the volumes added on creation are mounted
+func mountEphemeralTemplates(p *corev1.Pod, templates []secretTemplate, secretName string) *corev1.Pod {
+	volumes, mounts := templateVolumes(templates, secretName)
+	selector := newContainerSelector(p)
+	for i, volume := range volumes {
+		if !hasVolume(p.Spec.Volumes, volume.Name) {
+			continue
+		}
+		for j := range p.Spec.EphemeralContainers {
+			c := &p.Spec.EphemeralContainers[j]
+			if selector.selectsEphemeral(c.Name) && !hasVolumeMount(c.VolumeMounts, mounts[i].MountPath) {
+				c.VolumeMounts = append(c.VolumeMounts, mounts[i])
+			}
+		}
+	}
+	return p
+}
+
+// templateVolumes returns the secret volume of each directory the templates are mounted in, and its mount
+func templateVolumes(templates []secretTemplate, secretName string) ([]corev1.Volume, []corev1.VolumeMount) {
+	mode := int32(0444)
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	for _, t := range templates {
+		dir := path.Dir(t.path)
+		i := 0
+		for i < len(mounts) && mounts[i].MountPath != dir {
+			i++
+		}
+		if i == len(mounts) {
+			name := templateVolumeName
+			if i > 0 {
+				name = fmt.Sprintf("%v-%d", templateVolumeName, i)
+			}
+			volumes = append(volumes, corev1.Volume{
+				Name: name,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName:  secretName,
+						DefaultMode: &mode,
+					},
+				},
+			})
+			mounts = append(mounts, corev1.VolumeMount{Name: name, ReadOnly: true, MountPath: dir})
+		}
+		volumes[i].Secret.Items = append(volumes[i].Secret.Items, corev1.KeyToPath{Key: t.name, Path: path.Base(t.path)})
+	}
+	return volumes, mounts
+}
+
+// appendVolumeMount appends the mount to the selected containers, unless the container already mounts at the path
+func appendVolumeMount(containers []corev1.Container, selector containerSelector, mount corev1.VolumeMount) []corev1.Container {
+	for i := range containers {
+		if !selector.selects(containers[i].Name) || hasVolumeMount(containers[i].VolumeMounts, mount.MountPath) {
+			continue
+		}
+		containers[i].VolumeMounts = append(containers[i].VolumeMounts, mount)
+	}
+	return containers
+}
+
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolumeMount(mounts []corev1.VolumeMount, mountPath string) bool {
+	for _, m := range mounts {
+		if m.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/dap-secret-webhook/test/mocks"
+)
+
+func TestParseTemplates(t *testing.T) {
+	exposed := []*core.Secret{{Key: "db_password"}}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		paths       map[string]string
+		err         error
+	}{
+		{
+			name: "default path",
+			annotations: map[string]string{
+				TemplateAnnotationPrefix + "pgpass": "db:5432:*:admin:{{ .db_password }}",
+			},
+			paths: map[string]string{"pgpass": "/etc/dap-secret-webhook/templates/pgpass"},
+		},
+		{
+			name: "custom path",
+			annotations: map[string]string{
+				TemplateAnnotationPrefix + "pgpass":     "db:5432:*:admin:{{ .db_password }}",
+				TemplatePathAnnotationPrefix + "pgpass": "/etc/pgpass/.pgpass",
+			},
+			paths: map[string]string{"pgpass": "/etc/pgpass/.pgpass"},
+		},
+		{
+			name: "relative path",
+			annotations: map[string]string{
+				TemplateAnnotationPrefix + "pgpass":     "{{ .db_password }}",
+				TemplatePathAnnotationPrefix + "pgpass": ".pgpass",
+			},
+			err: fmt.Errorf("path '.pgpass' of template 'pgpass' is not absolute"),
+		},
+		{
+			name: "path in the root directory",
+			annotations: map[string]string{
+				TemplateAnnotationPrefix + "pgpass":     "{{ .db_password }}",
+				TemplatePathAnnotationPrefix + "pgpass": "/.pgpass",
+			},
+			err: fmt.Errorf("path '/.pgpass' of template 'pgpass' is in the root directory"),
+		},
+		{
+			name: "same path",
+			annotations: map[string]string{
+				TemplateAnnotationPrefix + "netrc":      "{{ .db_password }}",
+				TemplateAnnotationPrefix + "pgpass":     "{{ .db_password }}",
+				TemplatePathAnnotationPrefix + "pgpass": "/etc/dap-secret-webhook/templates/netrc",
+			},
+			err: fmt.Errorf("templates 'netrc' and 'pgpass' are mounted at the same path '/etc/dap-secret-webhook/templates/netrc'"),
+		},
+		{
+			name: "refer to secrets in every form",
+			annotations: map[string]string{
+				TemplateAnnotationPrefix + "pgpass": `{{ index . "db_password" }}{{ with .db_password }}{{ . }}{{ $.db_password }}{{ end }}`,
+			},
+			paths: map[string]string{"pgpass": "/etc/dap-secret-webhook/templates/pgpass"},
+		},
+		{
+			name:        "refer to unknown secret",
+			annotations: map[string]string{TemplateAnnotationPrefix + "pgpass": "{{ if .db_password }}{{ .db_user }}{{ end }}"},
+			err:         fmt.Errorf("template 'pgpass' refers to secret 'db_user' that is not exposed to the pod"),
+		},
+		{
+			name:        "refer to unknown secret with index",
+			annotations: map[string]string{TemplateAnnotationPrefix + "pgpass": `{{ index . "db-user" }}`},
+			err:         fmt.Errorf("template 'pgpass' refers to secret 'db-user' that is not exposed to the pod"),
+		},
+		{
+			name:        "refer to unknown secret in with",
+			annotations: map[string]string{TemplateAnnotationPrefix + "pgpass": "{{ with .db_password }}{{ $.db_user }}{{ end }}"},
+			err:         fmt.Errorf("template 'pgpass' refers to secret 'db_user' that is not exposed to the pod"),
+		},
+		{
+			name:        "path without template",
+			annotations: map[string]string{TemplatePathAnnotationPrefix + "netrc": "/home/flyte/.netrc"},
+			err:         fmt.Errorf("path is set for template 'netrc' that does not exist"),
+		},
+		{
+			name:        "clash with secret",
+			annotations: map[string]string{TemplateAnnotationPrefix + "db_password": "{{ .db_password }}"},
+			err:         fmt.Errorf("template 'db_password' clashes with secret 'db_password'"),
+		},
+		{
+			name:        "invalid template",
+			annotations: map[string]string{TemplateAnnotationPrefix + "pgpass": "{{ .db_password "},
+			err:         fmt.Errorf("invalid template 'pgpass': template: pgpass:1: unclosed action"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			templates, err := parseTemplates(pod, exposed)
+			assert.Equal(t, tt.err, err)
+			if tt.err == nil {
+				paths := map[string]string{}
+				for _, tmpl := range templates {
+					paths[tmpl.name] = tmpl.path
+				}
+				assert.Equal(t, tt.paths, paths)
+			}
+		})
+	}
+}
+
+func TestMutateTemplate(t *testing.T) {
+	newPod := func(template string) []byte {
+		annotations, err := secrets.MarshalSecretsToMapStrings([]*core.Secret{{Group: secretGroup, Key: secretKey}})
+		assert.NoError(t, err)
+		annotations[TemplateAnnotationPrefix+"netrc"] = template
+		annotations[TemplatePathAnnotationPrefix+"netrc"] = "/etc/netrc/.netrc"
+		raw, err := json.Marshal(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: secretGroup, Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+		assert.NoError(t, err)
+		return raw
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		rendered string
+		err      string
+	}{
+		{
+			name:     "ok",
+			template: "machine api.local login flyte password {{ .testsecretkey }}",
+			rendered: "machine api.local login flyte password secret_data",
+		},
+		{
+			name:     "unknown secret",
+			template: "machine api.local login flyte password {{ .unknown }}",
+			err:      "template 'netrc' refers to secret 'unknown' that is not exposed to the pod",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sClientSet := fake.NewSimpleClientset()
+			mlpClient := &mocks.MLPClient{}
+			mlpClient.On("GetMLPSecretValue", mock.Anything, secretGroup, secretKey).Return("secret_data", nil)
+			dapWebhook := NewDAPWebhook(k8sClientSet, nil, mlpClient, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+
+			resp := dapWebhook.Mutate(context.Background(), v1.AdmissionReview{Request: &v1.AdmissionRequest{
+				Operation: v1.Create,
+				Object:    runtime.RawExtension{Raw: newPod(tt.template)},
+			}})
+			if tt.err != "" {
+				assert.False(t, resp.Allowed)
+				assert.Equal(t, tt.err, resp.Result.Message)
+				return
+			}
+			assert.True(t, resp.Allowed)
+			assert.Contains(t, string(resp.Patch), `{"op":"add","path":"/spec/containers/0/volumeMounts",`+
+				`"value":[{"mountPath":"/etc/netrc","name":"dap-secret-webhook-templates","readOnly":true}]}`)
+			assert.Contains(t, string(resp.Patch), `"secret":{"defaultMode":292,"items":[{"key":"netrc","path":".netrc"}],"secretName":"pod"}`)
+
+			secret, err := k8sClientSet.CoreV1().Secrets(secretGroup).Get(context.Background(), "pod", metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.rendered, string(secret.Data["netrc"]))
+		})
+	}
+}
+
+func TestMountTemplates(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			TemplateAnnotationPrefix + "netrc":         "{{ .db_password }}",
+			TemplateAnnotationPrefix + "pgpass":        "{{ .db_password }}",
+			TemplateAnnotationPrefix + "pgservice":     "{{ .db_password }}",
+			TemplatePathAnnotationPrefix + "pgpass":    "/etc/pg/.pgpass",
+			TemplatePathAnnotationPrefix + "pgservice": "/etc/pg/.pg_service.conf",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	templates, err := parseTemplates(pod, []*core.Secret{{Key: "db_password"}})
+	assert.NoError(t, err)
+
+	mode := int32(0444)
+	expectedVolumes := []corev1.Volume{
+		{
+			Name: "dap-secret-webhook-templates",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+				SecretName:  "pod",
+				DefaultMode: &mode,
+				Items:       []corev1.KeyToPath{{Key: "netrc", Path: "netrc"}},
+			}},
+		},
+		{
+			Name: "dap-secret-webhook-templates-1",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+				SecretName:  "pod",
+				DefaultMode: &mode,
+				Items:       []corev1.KeyToPath{{Key: "pgpass", Path: ".pgpass"}, {Key: "pgservice", Path: ".pg_service.conf"}},
+			}},
+		},
+	}
+	expectedMounts := []corev1.VolumeMount{
+		{Name: "dap-secret-webhook-templates", ReadOnly: true, MountPath: "/etc/dap-secret-webhook/templates"},
+		{Name: "dap-secret-webhook-templates-1", ReadOnly: true, MountPath: "/etc/pg"},
+	}
+
+	// the directories are mounted once on reinvocation
+	for i := 0; i < 2; i++ {
+		pod = mountTemplates(pod, templates, "pod")
+		assert.Equal(t, expectedVolumes, pod.Spec.Volumes)
+		assert.Equal(t, expectedMounts, pod.Spec.Containers[0].VolumeMounts)
+	}
+}
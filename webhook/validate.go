@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	secretUtils "github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+
+	v1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/mlp/api/log"
+	"github.com/caraml-dev/mlp/api/pkg/instrumentation/metrics"
+)
+
+const ValidationsTotal string = "flyte_dsw_webhook_validations_total"
+
+var ValidationsTotalMetrics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: ValidationsTotal,
+	Help: "Number of pod validated by Webhook",
+},
+	[]string{"project", "status"},
+)
+
+/*
+Validate checks that a pod created with Flyte Secrets was mutated as intended.
+It is called after all mutation, so the pod is expected to have the env var injected by Mutate.
+
+The pod is rejected when
+  - the Flyte Secret annotations cannot be decoded or use an unsupported mount requirement
+  - the secret key is not a valid k8 secret data key, or the expansion of structured secrets or the template is invalid
+  - a container selected for injection does not reference the secret created for the pod
+  - a container excluded from injection references the secret created for the pod
+  - a user provided env var shadows the env var of the secret
+*/
+func (pm *DAPWebhook) Validate(ar v1.AdmissionReview) *v1.AdmissionResponse {
+	// only pod creation is mutated, other operations have nothing to validate
+	if ar.Request.Operation != v1.Create {
+		return &v1.AdmissionResponse{Allowed: true}
+	}
+
+	pod := &corev1.Pod{}
+	if _, _, err := pm.decoder.Decode(ar.Request.Object.Raw, nil, pod); err != nil {
+		return toAdmissionResponse(http.StatusBadRequest, err)
+	}
+
+	err := pm.validatePodSecrets(pod)
+	ValidationsTotalMetrics.WithLabelValues(pod.Namespace, metrics.GetStatusString(err == nil)).Inc()
+	if err != nil {
+		log.Errorf("rejected pod: '%v' in namespace: '%v': %v", pod.Name, pod.Namespace, err)
+		return toAdmissionResponse(http.StatusBadRequest, err)
+	}
+	return &v1.AdmissionResponse{Allowed: true}
+}
+
+// validatePodSecrets returns an error describing every problem found with the Flyte Secrets of the pod
+func (pm *DAPWebhook) validatePodSecrets(pod *corev1.Pod) error {
+	secrets, err := secretUtils.UnmarshalStringMapToSecrets(pod.GetAnnotations())
+	if err != nil {
+		return fmt.Errorf("invalid flyte secret annotations: %v", err)
+	}
+
+	expansions, err := parseExpansions(pod, secrets)
+	if err != nil {
+		return err
+	}
+
+	if _, err := parseTemplates(pod, expandSecrets(secrets, expansions)); err != nil {
+		return err
+	}
+
+	secretName := secretNameForPod(pod, secrets, pm.secretConfig)
+	selector := newContainerSelector(pod)
+	containers := make([]corev1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	containers = append(containers, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+
+	var errs []string
+	for _, c := range containers {
+		if !selector.selects(c.Name) && referencesSecret(c, secretName) {
+			errs = append(errs, fmt.Sprintf("container '%v' is excluded from injection but references secret '%v'", c.Name, secretName))
+		}
+	}
+	for _, secret := range expandSecrets(secrets, expansions) {
+		if msgs := validation.IsConfigMapKey(secret.Key); len(msgs) > 0 {
+			errs = append(errs, fmt.Sprintf("secret key '%v' is not a valid secret data key: %v", secret.Key, strings.Join(msgs, ", ")))
+			continue
+		}
+
+		switch secret.MountRequirement {
+		case core.Secret_ANY, core.Secret_ENV_VAR:
+		default:
+			errs = append(errs, fmt.Sprintf("unrecognized mount requirement [%v] for secret [%v]", secret.MountRequirement.String(), secret.Key))
+			continue
+		}
+
+		// the alias named after the MLP secret is a convenience and is not validated
+		envVarName := envVarNameForSecret(secret, pm.secretConfig)
+		for _, c := range containers {
+			if !selector.selects(c.Name) {
+				continue
+			}
+			if msg := validateContainerEnvVar(c, envVarName, secretName, secret.Key); msg != "" {
+				errs = append(errs, msg)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateContainerEnvVar checks that the env var of the secret is present exactly once and reads from the created secret
+func validateContainerEnvVar(c corev1.Container, envVarName string, secretName string, secretKey string) string {
+	var found []corev1.EnvVar
+	for _, env := range c.Env {
+		if env.Name == envVarName {
+			found = append(found, env)
+		}
+	}
+
+	switch {
+	case len(found) == 0:
+		return fmt.Sprintf("container '%v' does not reference secret '%v' with env var '%v'", c.Name, secretKey, envVarName)
+	case len(found) > 1:
+		return fmt.Sprintf("container '%v' defines env var '%v' more than once", c.Name, envVarName)
+	}
+
+	ref := found[0].ValueFrom
+	if ref == nil || ref.SecretKeyRef == nil || ref.SecretKeyRef.Name != secretName || ref.SecretKeyRef.Key != secretKey {
+		return fmt.Sprintf("container '%v' env var '%v' is shadowed by a user provided value", c.Name, envVarName)
+	}
+	return ""
+}
+
+// referencesSecret returns true when an env var of the container reads from the secret
+func referencesSecret(c corev1.Container, secretName string) bool {
+	for _, env := range c.Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == secretName {
+			return true
+		}
+	}
+	return false
+}
+
+func generateValidatingWebhookConfig(webhookConfig config.WebhookConfig, caCertFilePath string) (*admissionregistrationv1.ValidatingWebhookConfiguration, error) {
+	caBytes, err := os.ReadFile(caCertFilePath)
+	if err != nil {
+		return nil, err
+	}
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+
+	validateConfig := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      webhookConfig.Name,
+			Namespace: webhookConfig.Namespace,
+			Labels: map[string]string{
+				"app": webhookConfig.Name,
+			},
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				// needs to be a valid dns
+				Name:         webhookConfig.WebhookName,
+				ClientConfig: webhookClientConfig(webhookConfig, webhookConfig.ValidatePath, caBytes),
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					},
+				},
+				FailurePolicy:  failurePolicy(webhookConfig),
+				SideEffects:    &sideEffects,
+				TimeoutSeconds: timeoutSeconds(webhookConfig),
+				AdmissionReviewVersions: []string{
+					"v1",
+					"v1beta1",
+				},
+				ObjectSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						secretUtils.PodLabel: secretUtils.PodLabelValue,
+					},
+				},
+			}},
+	}
+
+	return validateConfig, nil
+}
+
+// CreateOrUpdateValidatingWebhookConfig will create/update the ValidatingWebhookConfiguration.
+// It will read the CA file, so if there are any update to the bundle, the CA will be updated
+func CreateOrUpdateValidatingWebhookConfig(k8sClient kubernetes.Interface, webhookConfig config.WebhookConfig, caCertFilePath string) error {
+
+	validateConfig, err := generateValidatingWebhookConfig(webhookConfig, caCertFilePath)
+	if err != nil {
+		return err
+	}
+
+	webhookClient := k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	ctx := context.Background()
+
+	log.Infof("Creating ValidatingWebhookConfiguration")
+	_, err = webhookClient.Create(ctx, validateConfig, metav1.CreateOptions{})
+
+	if err != nil && k8errors.IsAlreadyExists(err) {
+		log.Infof("Failed to create ValidatingWebhookConfiguration. Will attempt to update. Error: %v", err)
+		obj, getErr := webhookClient.Get(ctx, validateConfig.Name, metav1.GetOptions{})
+		if getErr != nil {
+			log.Infof("Failed to get ValidatingWebhookConfiguration. Error: %v", getErr)
+			return err
+		}
+
+		obj.Webhooks = validateConfig.Webhooks
+		_, err = webhookClient.Update(ctx, obj, metav1.UpdateOptions{})
+		if err != nil {
+			log.Infof("Failed to update existing validating webhook config. Error: %v", err)
+			return err
+		}
+	} else if err != nil {
+		log.Infof("Failed to create ValidatingWebhookConfiguration. Error: %v", err)
+		return err
+	}
+
+	log.Infof("ValidatingWebhookConfiguration configured")
+	return nil
+}
+
+// DeleteValidatingWebhookConfig deletes the ValidatingWebhookConfiguration. It is not an error when the config does
+// not exist
+func DeleteValidatingWebhookConfig(ctx context.Context, k8sClient kubernetes.Interface, webhookConfig config.WebhookConfig) error {
+	err := k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(ctx, webhookConfig.Name, metav1.DeleteOptions{})
+	if err != nil && !k8errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ValidatingWebhookConfiguration: %v", err)
+	}
+	log.Infof("ValidatingWebhookConfiguration deleted")
+	return nil
+}
@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/flyteorg/flyteidl/gen/pb-go/flyteidl/core"
+	"github.com/flyteorg/flyteplugins/go/tasks/pluginmachinery/utils/secrets"
+	"github.com/stretchr/testify/assert"
+
+	v1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/caraml-dev/dap-secret-webhook/config"
+	"github.com/caraml-dev/dap-secret-webhook/test/mocks"
+)
+
+func TestValidate(t *testing.T) {
+	dapWebhook := NewDAPWebhook(fake.NewSimpleClientset(), nil, &mocks.MLPClient{}, codecs.UniversalDeserializer(), nil, config.SecretConfig{}, 0)
+
+	secretEnvVar := corev1.EnvVar{
+		Name: "_FSEC_TESTGROUP_TESTSECRETKEY",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "pod-with-secret"},
+				Key:                  secretKey,
+			},
+		},
+	}
+	newPod := func(secret *core.Secret, env ...corev1.EnvVar) []byte {
+		annotations, err := secrets.MarshalSecretsToMapStrings([]*core.Secret{secret})
+		assert.NoError(t, err)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod-with-secret",
+				Namespace:   secretGroup,
+				Annotations: annotations,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Env: env}},
+			},
+		}
+		raw, err := json.Marshal(pod)
+		assert.NoError(t, err)
+		return raw
+	}
+	validSecret := &core.Secret{Group: secretGroup, Key: secretKey, MountRequirement: core.Secret_ENV_VAR}
+	newPodWithSidecar := func(sidecarEnv ...corev1.EnvVar) []byte {
+		pod := &corev1.Pod{}
+		assert.NoError(t, json.Unmarshal(newPod(validSecret, secretEnvVar), pod))
+		pod.Annotations[ExcludeContainersAnnotation] = "istio-proxy"
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "istio-proxy", Env: sidecarEnv})
+		raw, err := json.Marshal(pod)
+		assert.NoError(t, err)
+		return raw
+	}
+
+	tests := []struct {
+		name      string
+		operation v1.Operation
+		raw       []byte
+		resp      *v1.AdmissionResponse
+	}{
+		{
+			name:      "ok mutated pod",
+			operation: v1.Create,
+			raw:       newPod(validSecret, secretEnvVar),
+			resp:      &v1.AdmissionResponse{Allowed: true},
+		},
+		{
+			name:      "ok excluded container not injected",
+			operation: v1.Create,
+			raw:       newPodWithSidecar(),
+			resp:      &v1.AdmissionResponse{Allowed: true},
+		},
+		{
+			name:      "excluded container references secret",
+			operation: v1.Create,
+			raw:       newPodWithSidecar(secretEnvVar),
+			resp: &v1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusBadRequest,
+					Message: "container 'istio-proxy' is excluded from injection but references secret 'pod-with-secret'",
+				},
+			},
+		},
+		{
+			name:      "ok delete",
+			operation: v1.Delete,
+			resp:      &v1.AdmissionResponse{Allowed: true},
+		},
+		{
+			name:      "secret not referenced",
+			operation: v1.Create,
+			raw:       newPod(validSecret),
+			resp: &v1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusBadRequest,
+					Message: "container 'app' does not reference secret 'testsecretkey' with env var '_FSEC_TESTGROUP_TESTSECRETKEY'",
+				},
+			},
+		},
+		{
+			name:      "secret shadowed by user env var",
+			operation: v1.Create,
+			raw:       newPod(validSecret, corev1.EnvVar{Name: "_FSEC_TESTGROUP_TESTSECRETKEY", Value: "user"}),
+			resp: &v1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusBadRequest,
+					Message: "container 'app' env var '_FSEC_TESTGROUP_TESTSECRETKEY' is shadowed by a user provided value",
+				},
+			},
+		},
+		{
+			name:      "secret env var duplicated",
+			operation: v1.Create,
+			raw:       newPod(validSecret, secretEnvVar, secretEnvVar),
+			resp: &v1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusBadRequest,
+					Message: "container 'app' defines env var '_FSEC_TESTGROUP_TESTSECRETKEY' more than once",
+				},
+			},
+		},
+		{
+			name:      "invalid secret key",
+			operation: v1.Create,
+			raw:       newPod(&core.Secret{Group: secretGroup, Key: "bad/key", MountRequirement: core.Secret_ENV_VAR}),
+			resp: &v1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code: http.StatusBadRequest,
+					Message: "secret key 'bad/key' is not a valid secret data key: " +
+						"a valid config key must consist of alphanumeric characters, '-', '_' or '.' " +
+						"(e.g. 'key.name',  or 'KEY_NAME',  or 'key-name', regex used for validation is '[-._a-zA-Z0-9]+')",
+				},
+			},
+		},
+		{
+			name:      "unsupported mount requirement",
+			operation: v1.Create,
+			raw:       newPod(&core.Secret{Group: secretGroup, Key: secretKey, MountRequirement: core.Secret_FILE}),
+			resp: &v1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusBadRequest,
+					Message: "unrecognized mount requirement [FILE] for secret [testsecretkey]",
+				},
+			},
+		},
+		{
+			name:      "malformed annotation",
+			operation: v1.Create,
+			raw:       []byte(`{"metadata":{"annotations":{"flyte.secrets/s0":"not-base32"}}}`),
+			resp: &v1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusBadRequest,
+					Message: "invalid flyte secret annotations: error unmarshaling secret [flyte.secrets/s0]. Error: illegal base32 data at input byte 3",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := dapWebhook.Validate(v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Operation: tt.operation,
+					Object:    runtime.RawExtension{Raw: tt.raw},
+				},
+			})
+			assert.Equal(t, tt.resp, resp)
+		})
+	}
+}
+
+func TestValidatingWebhookConfig(t *testing.T) {
+	config := config.WebhookConfig{
+		Name:           "wh_name",
+		ServiceName:    "service-name",
+		WebhookName:    "local.cluster.svc",
+		ServicePort:    8080,
+		ValidatePath:   "/validate",
+		TimeoutSeconds: 10,
+	}
+	certPath := "../test/mutate/dummy_ca.cert"
+	output, err := generateValidatingWebhookConfig(config, certPath)
+	assert.NoError(t, err)
+
+	yamlData, err := os.ReadFile("../test/validate/webhook.yaml")
+	assert.NoError(t, err)
+	expected := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	assert.NoError(t, yaml.Unmarshal(yamlData, expected))
+	assert.Equal(t, expected, output)
+
+	k8Client := fake.NewSimpleClientset()
+	err = CreateOrUpdateValidatingWebhookConfig(k8Client, config, certPath)
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, DeleteValidatingWebhookConfig(context.Background(), k8Client, config))
+	}
+	_, err = k8Client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), config.Name, metav1.GetOptions{})
+	assert.True(t, k8errors.IsNotFound(err))
+}